@@ -0,0 +1,245 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+/*
+#include <yara.h>
+*/
+import "C"
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"sort"
+)
+
+// AtomQualityWarningThreshold is the atom quality below which libyara
+// warns about slow strings if no atom quality table is used.
+const AtomQualityWarningThreshold = maxAtomQuality - 20*maxAtomLength + 38
+
+// maxAtomLength and maxAtomQuality are the maximum length and quality
+// of atoms.
+const (
+	maxAtomLength  = C.YR_MAX_ATOM_LENGTH
+	maxAtomQuality = C.YR_MAX_ATOM_QUALITY
+)
+
+// atomQualityEntrySize is the size of a YR_ATOM_QUALITY_TABLE_ENTRY:
+// 4 bytes of atom, followed by 1 byte of quality.
+const atomQualityEntrySize = C.sizeof_YR_ATOM_QUALITY_TABLE_ENTRY
+
+// SetAtomQualityTable makes the compiler use an atom quality table
+// instead of its built-in heuristics when choosing atoms. The table
+// consists of 5-byte entries, 4 bytes of atom followed by a quality
+// value, sorted by atom. Atoms that are not in the table are
+// considered to be of maximum quality. A warning is produced for
+// strings whose best atom's quality is below warningThreshold.
+//
+// See also: yr_compiler_set_atom_quality_table in the YARA C API
+// documentation.
+func (c *Compiler) SetAtomQualityTable(table []byte, warningThreshold uint8) error {
+	if len(table) == 0 || len(table)%atomQualityEntrySize != 0 {
+		return errors.New("atom quality table size must be a positive multiple of 5")
+	}
+	for i := atomQualityEntrySize; i < len(table); i += atomQualityEntrySize {
+		if bytes.Compare(table[i-atomQualityEntrySize:i-1], table[i:i+4]) > 0 {
+			return errors.New("atom quality table is not sorted")
+		}
+	}
+	// libyara does not copy the table.
+	ptr := C.CBytes(table)
+	C.yr_compiler_set_atom_quality_table(
+		c.cptr, ptr, C.int(len(table)/atomQualityEntrySize), C.uchar(warningThreshold))
+	if c.atomTable != nil {
+		C.free(c.atomTable)
+	}
+	c.atomTable = ptr
+	c.atomQualityTable = append([]byte(nil), table...)
+	runtime.KeepAlive(c)
+	return nil
+}
+
+// ReadAtomQualityTable reads an atom quality table from r. See
+// SetAtomQualityTable.
+func (c *Compiler) ReadAtomQualityTable(r io.Reader, warningThreshold uint8) error {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.SetAtomQualityTable(buf, warningThreshold)
+}
+
+// LoadAtomQualityTable loads an atom quality table from a file. See
+// SetAtomQualityTable.
+func (c *Compiler) LoadAtomQualityTable(filename string, warningThreshold uint8) error {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return c.SetAtomQualityTable(buf, warningThreshold)
+}
+
+// An atomQualityFunc rates an atom given as bytes and masks.
+type atomQualityFunc func(atom, mask []byte) int
+
+// atomQuality returns the function that libyara uses to rate atoms
+// for the compiler.
+func (c *Compiler) atomQuality() atomQualityFunc {
+	if table := c.atomQualityTable; table != nil {
+		return func(atom, mask []byte) int { return tableAtomQuality(table, atom) }
+	}
+	return heuristicAtomQuality
+}
+
+// heuristicAtomQuality is a port of libyara's
+// yr_atoms_heuristic_quality.
+func heuristicAtomQuality(atom, mask []byte) int {
+	var seen [256]bool
+	quality, unique := 0, 0
+	for i, b := range atom {
+		switch mask[i] {
+		case 0x00:
+			quality += 2
+		case 0x0f, 0xf0:
+			quality += 4
+		case 0xff:
+			switch {
+			case b == 0x00 || b == 0x20 || b == 0xcc || b == 0xff:
+				quality += 12
+			case b|0x20 >= 'a' && b|0x20 <= 'z':
+				quality += 18
+			default:
+				quality += 20
+			}
+			if !seen[b] {
+				seen[b] = true
+				unique++
+			}
+		}
+	}
+	if unique == 1 && (seen[0x00] || seen[0x20] || seen[0x90] || seen[0xcc] || seen[0xff]) {
+		quality -= 10 * len(atom)
+	} else {
+		quality += 2 * unique
+	}
+	return maxAtomQuality - 22*maxAtomLength + quality
+}
+
+// tableAtomQuality is a port of libyara's quality table lookup: The
+// lowest quality of all entries that start with atom is used, scaled
+// down for short atoms.
+func tableAtomQuality(table, atom []byte) int {
+	entry := func(i int) []byte {
+		return table[i*atomQualityEntrySize : i*atomQualityEntrySize+len(atom)]
+	}
+	n := len(table) / atomQualityEntrySize
+	i := sort.Search(n, func(i int) bool { return bytes.Compare(entry(i), atom) >= 0 })
+	if i == n || !bytes.Equal(entry(i), atom) {
+		return maxAtomQuality
+	}
+	quality := maxAtomQuality
+	for ; i < n && bytes.Equal(entry(i), atom); i++ {
+		if q := int(table[i*atomQualityEntrySize+4]); q < quality {
+			quality = q
+		}
+	}
+	return quality >> uint(maxAtomLength-len(atom))
+}
+
+// An AtomCounter counts the 4-byte sequences that occur in a sample
+// corpus so that an atom quality table can be derived from it.
+// Memory usage grows with the number of distinct sequences.
+type AtomCounter struct {
+	counts map[[4]byte]uint64
+	total  uint64
+}
+
+// Add counts the 4-byte sequences in one sample that is read from r.
+func (ac *AtomCounter) Add(r io.Reader) error {
+	// The last 3 bytes of every chunk are carried over to the next
+	// one, so that each sequence is counted exactly once.
+	buf := make([]byte, 3+64*1024)
+	var n int
+	for {
+		m, err := r.Read(buf[n:])
+		if m > 0 {
+			n += m
+			ac.AddBytes(buf[:n])
+			if n > 3 {
+				n = copy(buf, buf[n-3:n])
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// AddBytes counts the 4-byte sequences in one sample.
+func (ac *AtomCounter) AddBytes(buf []byte) {
+	var atom [4]byte
+	for i := 0; i+4 <= len(buf); i++ {
+		copy(atom[:], buf[i:i+4])
+		ac.count(atom)
+	}
+}
+
+func (ac *AtomCounter) count(atom [4]byte) {
+	if ac.counts == nil {
+		ac.counts = make(map[[4]byte]uint64)
+	}
+	ac.counts[atom]++
+	ac.total++
+}
+
+// QualityTable returns an atom quality table for use with
+// SetAtomQualityTable that contains the maxEntries most frequent
+// sequences, or all sequences if maxEntries is negative. The quality
+// of an atom is derived from the frequency f of its occurrence as
+// -8*log2(f), limited to 255. Lower values denote worse atoms, so
+// that frequent atoms are avoided by the compiler; an atom that makes
+// up the whole corpus has quality 0.
+//
+// The table is empty if maxEntries is 0 or if no samples have been
+// added. SetAtomQualityTable rejects empty tables.
+func (ac *AtomCounter) QualityTable(maxEntries int) []byte {
+	type entry struct {
+		atom  [4]byte
+		count uint64
+	}
+	entries := make([]entry, 0, len(ac.counts))
+	for atom, count := range ac.counts {
+		entries = append(entries, entry{atom, count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return bytes.Compare(entries[i].atom[:], entries[j].atom[:]) < 0
+	})
+	if maxEntries >= 0 && len(entries) > maxEntries {
+		entries = entries[:maxEntries]
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].atom[:], entries[j].atom[:]) < 0
+	})
+	table := make([]byte, 0, len(entries)*atomQualityEntrySize)
+	for _, e := range entries {
+		quality := -8 * math.Log2(float64(e.count)/float64(ac.total))
+		if quality > 255 {
+			quality = 255
+		}
+		table = append(table, e.atom[:]...)
+		table = append(table, uint8(quality))
+	}
+	return table
+}
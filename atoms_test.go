@@ -0,0 +1,81 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+)
+
+func TestAtomCounter(t *testing.T) {
+	sample := bytes.Repeat([]byte("\x00\x00\x00\x00\xff\xff\xff\xffABCD"), 1000)
+	var ac1, ac2 AtomCounter
+	ac1.AddBytes(sample)
+	if err := ac2.Add(iotest.OneByteReader(bytes.NewReader(sample))); err != nil {
+		t.Fatal(err)
+	}
+	if ac1.total != uint64(len(sample)-3) || ac1.total != ac2.total {
+		t.Errorf("unexpected totals: %d, %d", ac1.total, ac2.total)
+	}
+	table := ac1.QualityTable(4)
+	if len(table) != 4*5 {
+		t.Fatalf("unexpected table size %d", len(table))
+	}
+	if !bytes.Equal(ac2.QualityTable(4), table) {
+		t.Error("tables differ")
+	}
+	c, _ := NewCompiler()
+	if err := c.SetAtomQualityTable(table, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddString(`rule a { strings: $a = { 00 00 00 00 ?? ?? 41 42 43 44 } condition: $a }`, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetRules(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetAtomQualityTable(table[5:], 0); err != nil {
+		t.Error(err)
+	}
+	if err := c.SetAtomQualityTable(append(table[5:10:10], table[:5]...), 0); err == nil {
+		t.Error("unsorted table was accepted")
+	}
+}
+
+func TestAtomQuality(t *testing.T) {
+	ff := []byte{0xff, 0xff, 0xff, 0xff}
+	for _, tc := range []struct {
+		atom, mask []byte
+		quality    int
+	}{
+		{[]byte("abcd"), ff, 247},
+		{[]byte{1, 2, 3, 4}, ff, 255},
+		{[]byte{0, 0, 0, 0}, ff, 175},
+		{[]byte{0x90, 0x90}, ff[:2], 187},
+		{[]byte{'a', 0, 1}, []byte{0xff, 0x00, 0x0f}, 193},
+		{nil, nil, 167},
+	} {
+		if q := heuristicAtomQuality(tc.atom, tc.mask); q != tc.quality {
+			t.Errorf("%x/%x: expected quality %d, got %d", tc.atom, tc.mask, tc.quality, q)
+		}
+	}
+	table := []byte("abcd\x40abce\x20bcde\x80")
+	for _, tc := range []struct {
+		atom    string
+		quality int
+	}{
+		{"abcd", 0x40},
+		{"abc", 0x10},
+		{"bcde", 0x80},
+		{"xyz", 255},
+	} {
+		if q := tableAtomQuality(table, []byte(tc.atom)); q != tc.quality {
+			t.Errorf("%q: expected quality %d, got %d", tc.atom, tc.quality, q)
+		}
+	}
+}
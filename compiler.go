@@ -23,11 +23,24 @@ static const char* rule_namespace(YR_RULE* r) {
 void compilerCallback(int, char*, int, YR_RULE*, char*, void*);
 char* includeCallback(char*, char*, char*, void*);
 void freeCallback(char*, void*);
+void reAstCallback(YR_RULE*, char*, RE_AST*, void*);
+
+// re_ast_callback matches YR_COMPILER_RE_AST_CALLBACK_FUNC and passes
+// its arguments to reAstCallback, which cannot take const pointers.
+static void re_ast_callback(
+    const YR_RULE* rule,
+    const char* string_identifier,
+    const RE_AST* re_ast,
+    void* user_data) {
+	reAstCallback((YR_RULE*) rule, (char*) string_identifier, (RE_AST*) re_ast, user_data);
+}
 */
 import "C"
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"runtime"
@@ -37,6 +50,11 @@ import (
 //export compilerCallback
 func compilerCallback(errorLevel C.int, filename *C.char, linenumber C.int, rule *C.YR_RULE, message *C.char, userData unsafe.Pointer) {
 	c := cgoHandle(*(*uintptr)(userData)).Value().(*Compiler)
+	defer func() {
+		if p := recover(); p != nil {
+			c.callbackErr = newCallbackPanicError(p)
+		}
+	}()
 	var text string
 	if rule != nil {
 		text = fmt.Sprintf("rule \"%s\": %s",
@@ -50,20 +68,38 @@ func compilerCallback(errorLevel C.int, filename *C.char, linenumber C.int, rule
 		Line:     int(linenumber),
 		Text:     text,
 	}
+	if msg.Filename == "" {
+		msg.Filename = c.filename
+	}
+	// A failed include is reported right after includeCallback has
+	// returned.
+	if c.include != nil && c.include.resolveErr != nil && errorLevel == C.YARA_ERROR_LEVEL_ERROR {
+		msg.Text += ": " + c.include.resolveErr.Error()
+		msg.Err, c.include.resolveErr = c.include.resolveErr, nil
+	}
 	if rule != nil {
-		msg.Rule = C.GoString(C.rule_namespace(rule))
-		if msg.Rule == "default" {
-			msg.Rule = C.GoString(C.rule_identifier(rule))
-		} else {
-			msg.Rule += "." + C.GoString(C.rule_identifier(rule))
-		}
+		msg.Rule = qualifiedRuleName(rule)
 	}
 	switch errorLevel {
 	case C.YARA_ERROR_LEVEL_ERROR:
+		msg.Severity = SeverityError
 		c.Errors = append(c.Errors, msg)
 	case C.YARA_ERROR_LEVEL_WARNING:
-		c.Warnings = append(c.Warnings, msg)
+		// Warnings are added to c.Warnings by applyWarningPolicy.
+		msg.Severity = SeverityWarning
+		msg.Category = classifyWarning(text)
 	}
+	c.messages = append(c.messages, msg)
+}
+
+// qualifiedRuleName returns the rule identifier, prefixed with the
+// namespace unless it is the default namespace.
+func qualifiedRuleName(rule *C.YR_RULE) string {
+	name := C.GoString(C.rule_namespace(rule))
+	if name == "default" {
+		return C.GoString(C.rule_identifier(rule))
+	}
+	return name + "." + C.GoString(C.rule_identifier(rule))
 }
 
 // A Compiler encapsulates the YARA compiler that transforms rules
@@ -78,6 +114,51 @@ type Compiler struct {
 	// used for include callback
 	callbackData *cgoHandle
 	cptr         *C.YR_COMPILER
+	// include is referenced by callbackData.
+	include *includeCallbackContainer
+	// callbackErr records a panic in compilerCallback.
+	callbackErr error
+	// messages collects errors and warnings produced by the
+	// current AddXxx call.
+	messages []CompilerMessage
+	// filename is the name passed to the current AddXxx call.
+	filename string
+	// includes is the include graph.
+	includes []Include
+	// includesDisabled is set by DisableIncludes.
+	includesDisabled bool
+	// variables records the variables that have been defined, for
+	// TryAddBytes.
+	variables map[string]interface{}
+	// warnings contains all warnings, including those that have
+	// been suppressed, for GetRules.
+	warnings []CompilerMessage
+	// warningActions and warningMetaKey are set through
+	// SetWarningAction and SetWarningMetaKey.
+	warningActions map[WarningCategory]WarningAction
+	warningMetaKey string
+	// regexAnalysis is set by EnableRegexAnalysis.
+	regexAnalysis *regexAnalysis
+	// atomTable is the atom quality table that has been passed to
+	// SetAtomQualityTable; libyara does not copy it.
+	// atomQualityTable is a Go copy that is used by regex analysis.
+	atomTable        unsafe.Pointer
+	atomQualityTable []byte
+}
+
+// CompilerMessageSeverity distinguishes errors from warnings.
+type CompilerMessageSeverity int
+
+const (
+	SeverityError CompilerMessageSeverity = iota
+	SeverityWarning
+)
+
+func (s CompilerMessageSeverity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
 }
 
 // A CompilerMessage contains an error or warning message produced
@@ -87,8 +168,29 @@ type CompilerMessage struct {
 	Line     int
 	Text     string
 	Rule     string
+	// String is the identifier of the string that the message
+	// refers to, if known.
+	String   string
+	Severity CompilerMessageSeverity
+	// Err is the underlying error, e.g. the error returned by an
+	// IncludeResolver.
+	Err error
+	// Category is set for warnings.
+	Category WarningCategory
 }
 
+// Error implements the error interface, so that a CompilerMessage
+// can be found in the chain returned by (*CompileError).Unwrap.
+func (m CompilerMessage) Error() string {
+	if m.Filename != "" {
+		return fmt.Sprintf("%s:%d: %s", m.Filename, m.Line, m.Text)
+	}
+	return fmt.Sprintf("line %d: %s", m.Line, m.Text)
+}
+
+// Unwrap returns Err.
+func (m CompilerMessage) Unwrap() error { return m.Err }
+
 // NewCompiler creates a YARA compiler.
 func NewCompiler() (*Compiler, error) {
 	var yrCompiler *C.YR_COMPILER
@@ -113,17 +215,35 @@ func (c *Compiler) Destroy() {
 		C.free(unsafe.Pointer(c.callbackData))
 		c.callbackData = nil
 	}
+	if c.atomTable != nil {
+		C.free(c.atomTable)
+		c.atomTable = nil
+	}
 	runtime.SetFinalizer(c, nil)
 }
 
-func (c *Compiler) setCallbackData(cb CompilerIncludeFunc) {
+func (c *Compiler) setCallbackData(r IncludeResolver) {
 	if *c.callbackData != 0 {
 		c.callbackData.Delete()
 		*c.callbackData = 0
 	}
-	if cb != nil {
-		*c.callbackData = cgoNewHandle(cb)
+	c.include = nil
+	if r != nil {
+		c.include = &includeCallbackContainer{IncludeResolver: r}
+		c.include.reset("")
+		*c.callbackData = cgoNewHandle(c.include)
+	}
+}
+
+// takeCallbackError returns and resets the error that has been
+// recorded while a callback function was called during compilation.
+func (c *Compiler) takeCallbackError() (err error) {
+	if c.include != nil && c.include.err != nil {
+		err, c.include.err = c.include.err, nil
+	} else if c.callbackErr != nil {
+		err, c.callbackErr = c.callbackErr, nil
 	}
+	return
 }
 
 var (
@@ -140,47 +260,85 @@ func (c *Compiler) checkUsage() (err error) {
 	return
 }
 
-// AddFile compiles rules from a file. Rules are added to the
-// specified namespace.
-//
-// If this function returns an error, the Compiler object will become
-// unusable.
-func (c *Compiler) AddFile(file *os.File, namespace string) (err error) {
+// addSource runs add, one of the yr_compiler_add_xxx functions,
+// with compilerCallback set up. source is the rule text that is used
+// to render a CompileError and to find string declarations; it may be
+// nil if the rules are read from the file that is identified by
+// filename.
+func (c *Compiler) addSource(filename, namespace string, source []byte, add func() C.int) (err error) {
 	if err := c.checkUsage(); err != nil {
 		return err
 	}
-	var ns *C.char
-	if namespace != "" {
-		ns = C.CString(namespace)
-		defer C.free(unsafe.Pointer(ns))
+	c.messages = nil
+	c.filename = filename
+	if c.include != nil {
+		c.include.reset(filename)
 	}
-	filename := C.CString(file.Name())
-	defer C.free(unsafe.Pointer(filename))
 	id := cgoNewHandle(c)
 	defer id.Delete()
 	cbp := unsafe.Pointer(&id)
 	C.yr_compiler_set_callback(c.cptr, C.YR_COMPILER_CALLBACK_FUNC(C.compilerCallback), cbp)
-	numErrors := int(C._yr_compiler_add_fd(c.cptr, C.int(file.Fd()), ns, filename))
+	if c.regexAnalysis != nil {
+		C.yr_compiler_set_re_ast_callback(c.cptr, C.YR_COMPILER_RE_AST_CALLBACK_FUNC(C.re_ast_callback), cbp)
+	}
+	numErrors := int(add())
+	if c.include != nil {
+		c.includes = append(c.includes, c.include.includes...)
+	}
+	c.applyWarningPolicy()
 	if numErrors > 0 {
 		var buf [1024]C.char
 		msg := C.GoString(C.yr_compiler_get_error_message(
 			c.cptr, (*C.char)(unsafe.Pointer(&buf[0])), 1024))
-		err = errors.New(msg)
+		ce := &CompileError{
+			Code:     int(c.cptr.last_error),
+			Messages: c.messages,
+			text:     msg,
+			sources:  map[string][]byte{filename: source},
+		}
+		if c.include != nil {
+			for name, buf := range c.include.sources {
+				ce.sources[name] = buf
+			}
+		}
+		err = ce
 	}
+	if cbErr := c.takeCallbackError(); cbErr != nil {
+		err = cbErr
+	}
+	c.messages = nil
+	c.filename = ""
 	runtime.KeepAlive(c)
 	runtime.KeepAlive(cbp)
 	return
 }
 
+// AddFile compiles rules from a file. Rules are added to the
+// specified namespace.
+//
+// If the rules cannot be compiled, the returned error is a
+// *CompileError. If this function returns an error, the Compiler
+// object will become unusable.
+func (c *Compiler) AddFile(file *os.File, namespace string) (err error) {
+	var ns *C.char
+	if namespace != "" {
+		ns = C.CString(namespace)
+		defer C.free(unsafe.Pointer(ns))
+	}
+	filename := C.CString(file.Name())
+	defer C.free(unsafe.Pointer(filename))
+	return c.addSource(file.Name(), namespace, nil, func() C.int {
+		return C._yr_compiler_add_fd(c.cptr, C.int(file.Fd()), ns, filename)
+	})
+}
+
 // AddString compiles rules from a string. Rules are added to the
 // specified namespace.
 //
-// If this function returns an error, the Compiler object will become
-// unusable.
+// If the rules cannot be compiled, the returned error is a
+// *CompileError. If this function returns an error, the Compiler
+// object will become unusable.
 func (c *Compiler) AddString(rules string, namespace string) (err error) {
-	if err := c.checkUsage(); err != nil {
-		return err
-	}
 	var ns *C.char
 	if namespace != "" {
 		ns = C.CString(namespace)
@@ -188,20 +346,38 @@ func (c *Compiler) AddString(rules string, namespace string) (err error) {
 	}
 	crules := C.CString(rules)
 	defer C.free(unsafe.Pointer(crules))
-	id := cgoNewHandle(c)
-	defer id.Delete()
-	cbp := unsafe.Pointer(&id)
-	C.yr_compiler_set_callback(c.cptr, C.YR_COMPILER_CALLBACK_FUNC(C.compilerCallback), cbp)
-	numErrors := int(C.yr_compiler_add_string(c.cptr, crules, ns))
-	if numErrors > 0 {
-		var buf [1024]C.char
-		msg := C.GoString(C.yr_compiler_get_error_message(
-			c.cptr, (*C.char)(unsafe.Pointer(&buf[0])), 1024))
-		err = errors.New(msg)
+	return c.addSource("", namespace, []byte(rules), func() C.int {
+		return C.yr_compiler_add_string(c.cptr, crules, ns)
+	})
+}
+
+// AddBytes compiles rules from a byte slice. Rules are added to the
+// specified namespace. filename is used in CompilerMessage values and
+// as the base for relative include statements; it may be empty.
+//
+// If the rules cannot be compiled, the returned error is a
+// *CompileError. If this function returns an error, the Compiler
+// object will become unusable.
+func (c *Compiler) AddBytes(rules []byte, filename, namespace string) (err error) {
+	var ns *C.char
+	if namespace != "" {
+		ns = C.CString(namespace)
+		defer C.free(unsafe.Pointer(ns))
 	}
-	runtime.KeepAlive(c)
-	runtime.KeepAlive(cbp)
-	return
+	crules := C.CBytes(rules)
+	defer C.free(crules)
+	return c.addSource(filename, namespace, rules, func() C.int {
+		return C.yr_compiler_add_bytes(c.cptr, crules, C.size_t(len(rules)), ns)
+	})
+}
+
+// AddReader compiles rules that are read from r. See AddBytes.
+func (c *Compiler) AddReader(r io.Reader, filename, namespace string) (err error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.AddBytes(buf, filename, namespace)
 }
 
 // DefineVariable defines a named variable for use by the compiler.
@@ -232,15 +408,35 @@ func (c *Compiler) DefineVariable(identifier string, value interface{}) (err err
 	default:
 		err = errors.New("wrong value type passed to DefineVariable; bool, int64, float64, string are accepted")
 	}
+	if err == nil {
+		if c.variables == nil {
+			c.variables = make(map[string]interface{})
+		}
+		c.variables[identifier] = value
+	}
 	runtime.KeepAlive(c)
 	return
 }
 
 // GetRules returns the compiled ruleset.
+//
+// If warnings have been promoted to errors using SetWarningAction or
+// rule metas (see SetWarningMetaKey), GetRules returns a
+// *CompileError that contains them. Without a warning meta key, this
+// check is done before the rules are compiled, so the Compiler stays
+// usable: warning actions can be changed and GetRules can be called
+// again. Rule metas are only available after compilation, so if a
+// warning meta key has been set, the Compiler cannot be used after
+// GetRules has failed.
 func (c *Compiler) GetRules() (*Rules, error) {
 	if err := c.checkUsage(); err != nil {
 		return nil, err
 	}
+	if c.warningMetaKey == "" {
+		if err := c.checkPromotedWarnings(nil); err != nil {
+			return nil, err
+		}
+	}
 	var yrRules *C.YR_RULES
 	if err := newError(C.yr_compiler_get_rules(c.cptr, &yrRules)); err != nil {
 		return nil, err
@@ -248,15 +444,97 @@ func (c *Compiler) GetRules() (*Rules, error) {
 	r := &Rules{cptr: yrRules}
 	runtime.SetFinalizer(r, (*Rules).Destroy)
 	runtime.KeepAlive(c)
+	if c.warningMetaKey != "" {
+		if err := c.checkPromotedWarnings(r); err != nil {
+			r.Destroy()
+			return nil, err
+		}
+	}
 	return r, nil
 }
 
+// includeCallbackContainer is used to pass an IncludeResolver to
+// includeCallback and to record a panic that occurred within it.
+type includeCallbackContainer struct {
+	IncludeResolver
+	err error
+	// resolveErr is the last error returned by the IncludeResolver.
+	resolveErr error
+	// sources records the included rule texts for CompileError.
+	sources map[string][]byte
+	// filename is the name passed to the current AddXxx call.
+	filename string
+	// resolved contains the files that have been included, in
+	// order. libyara passes the name from the include statement as
+	// filename for nested includes, so it is used to find the
+	// including file's path.
+	resolved []resolvedInclude
+	// includes records the include statements of the current
+	// AddXxx call.
+	includes []Include
+}
+
+func (ic *includeCallbackContainer) reset(filename string) {
+	ic.resolveErr = nil
+	ic.sources = make(map[string][]byte)
+	ic.filename = filename
+	ic.resolved = nil
+	ic.includes = nil
+}
+
+// resolvedInclude maps the name from an include statement to the
+// path returned by the IncludeResolver.
+type resolvedInclude struct {
+	name, path string
+}
+
+// includingPath returns the path of the file that contains an include
+// statement, given the name that libyara passes as filename.
+//
+// The same name can refer to different files if it is included from
+// different directories. The most recent include with that name is
+// the right one: libyara rejects includes of a name that is still
+// being processed as circular, so every later include with the same
+// name has been processed completely.
+func (ic *includeCallbackContainer) includingPath(filename string) string {
+	for i := len(ic.resolved) - 1; i >= 0; i-- {
+		if ic.resolved[i].name == filename {
+			return ic.resolved[i].path
+		}
+	}
+	if filename == "" {
+		return ic.filename
+	}
+	return filename
+}
+
 //export includeCallback
-func includeCallback(name, filename, namespace *C.char, userData unsafe.Pointer) *C.char {
-	callbackFunc := cgoHandle(*(*uintptr)(userData)).Value().(CompilerIncludeFunc)
-	if buf := callbackFunc(
-		C.GoString(name), C.GoString(filename), C.GoString(namespace),
-	); buf != nil {
+func includeCallback(name, filename, namespace *C.char, userData unsafe.Pointer) (result *C.char) {
+	ic := cgoHandle(*(*uintptr)(userData)).Value().(*includeCallbackContainer)
+	// A panic is reported as a failed include. The compiler
+	// records an error and the panic is returned by AddString or
+	// AddFile.
+	defer func() {
+		if p := recover(); p != nil {
+			ic.err = newCallbackPanicError(p)
+			result = nil
+		}
+	}()
+	// Rules that are added using AddBytes or AddReader do not have a
+	// file name as far as libyara is concerned.
+	calling := ic.includingPath(C.GoString(filename))
+	iname, ins := C.GoString(name), C.GoString(namespace)
+	path, buf, err := ic.ResolveInclude(iname, calling, ins)
+	if err != nil {
+		ic.resolveErr = err
+		return nil
+	}
+	if buf != nil {
+		ic.resolved = append(ic.resolved, resolvedInclude{iname, path})
+		ic.sources[iname] = buf
+		ic.includes = append(ic.includes, Include{
+			Filename: calling, Name: iname, Path: path, Namespace: ins,
+		})
 		ptr := C.calloc(1, C.size_t(len(buf)+1))
 		if ptr == nil {
 			return nil
@@ -289,6 +567,12 @@ func freeCallback(callbackResultPtr *C.char, userData unsafe.Pointer) {
 // documentation.
 type CompilerIncludeFunc func(name, filename, namespace string) []byte
 
+// ResolveInclude implements IncludeResolver. A nil return value is
+// reported as a failed include without an error.
+func (f CompilerIncludeFunc) ResolveInclude(name, filename, namespace string) (string, []byte, error) {
+	return name, f(name, filename, namespace), nil
+}
+
 // SetIncludeCallback registers an include function that is called
 // (through Go glue code) by the YARA compiler for every include
 // statement.
@@ -297,7 +581,19 @@ func (c *Compiler) SetIncludeCallback(cb CompilerIncludeFunc) {
 		c.DisableIncludes()
 		return
 	}
-	c.setCallbackData(cb)
+	c.SetIncludeResolver(cb)
+}
+
+// SetIncludeResolver registers an IncludeResolver that is used for
+// every include statement instead of libyara's default include
+// handling. Passing nil disables includes.
+func (c *Compiler) SetIncludeResolver(r IncludeResolver) {
+	if r == nil {
+		c.DisableIncludes()
+		return
+	}
+	c.includesDisabled = false
+	c.setCallbackData(r)
 	C.yr_compiler_set_include_callback(
 		c.cptr,
 		C.YR_COMPILER_INCLUDE_CALLBACK_FUNC(C.includeCallback),
@@ -312,11 +608,44 @@ func (c *Compiler) SetIncludeCallback(cb CompilerIncludeFunc) {
 // See yr_compiler_set_include_callbacks.
 func (c *Compiler) DisableIncludes() {
 	C.yr_compiler_set_include_callback(c.cptr, nil, nil, nil)
+	c.includesDisabled = true
 	c.setCallbackData(nil)
 	runtime.KeepAlive(c)
 	return
 }
 
+// swapIncludeResolver installs r for the duration of an AddXxx call
+// and returns a function that restores the previous include
+// resolver or libyara's default include handling. If includes have
+// been disabled, they stay disabled.
+func (c *Compiler) swapIncludeResolver(r IncludeResolver) (restore func()) {
+	if c.includesDisabled {
+		return func() {}
+	}
+	if c.include != nil {
+		prev := c.include.IncludeResolver
+		c.SetIncludeResolver(r)
+		return func() { c.SetIncludeResolver(prev) }
+	}
+	cb, free, userData := c.cptr.include_callback, c.cptr.include_free, c.cptr.incl_clbk_user_data
+	c.SetIncludeResolver(r)
+	return func() {
+		c.setCallbackData(nil)
+		C.yr_compiler_set_include_callback(c.cptr, cb, free, userData)
+		runtime.KeepAlive(c)
+	}
+}
+
+// Includes returns the include statements that have been resolved by
+// the compiler so far, in the order in which they have been
+// processed. It can be used to determine which files a rule set has
+// been built from. Includes are only recorded while an
+// IncludeResolver is set; libyara's default include handling does
+// not report them.
+func (c *Compiler) Includes() []Include {
+	return append([]Include(nil), c.includes...)
+}
+
 // Compile compiles rules and an (optional) set of variables into a
 // Rules object in a single step.
 func Compile(rules string, variables map[string]interface{}) (r *Rules, err error) {
@@ -0,0 +1,174 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// CompileError is returned by the Compiler's AddXxx methods if rules
+// could not be compiled.
+type CompileError struct {
+	// Code is the YARA error code of the last error reported by
+	// the compiler. It is 0 if warnings have been treated as
+	// errors by GetRules; such errors match ErrWarningAsError and
+	// ErrCompile.
+	Code int
+	// Messages contains all errors and warnings that have been
+	// produced while compiling the rules, in the order they have
+	// been reported.
+	Messages []CompilerMessage
+	// text is the compiler's message for the last error. It is
+	// used if there are no error messages.
+	text string
+	// sources maps file names to rule texts, "" is used for rules
+	// passed as string.
+	sources map[string][]byte
+	// promoted is set if the error has been caused by warnings
+	// treated as errors.
+	promoted bool
+}
+
+// Error returns the error messages, including file name and line
+// number, separated by semicolons.
+func (e *CompileError) Error() string {
+	errs := e.Errors()
+	if len(errs) == 0 {
+		return e.text
+	}
+	msgs := make([]string, len(errs))
+	for i, m := range errs {
+		msgs[i] = m.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Errors returns the messages with SeverityError.
+func (e *CompileError) Errors() (msgs []CompilerMessage) {
+	for _, m := range e.Messages {
+		if m.Severity == SeverityError {
+			msgs = append(msgs, m)
+		}
+	}
+	return
+}
+
+// Unwrap returns a chain that consists of an Error carrying Code,
+// followed by the error messages, so that errors.Is(err,
+// ErrSyntaxError) and errors.As(err, &msg) with a CompilerMessage msg
+// can be used.
+func (e *CompileError) Unwrap() error {
+	var next error
+	errs := e.Errors()
+	for i := len(errs) - 1; i >= 0; i-- {
+		next = &errorChain{errs[i], next}
+	}
+	return &errorChain{Error{Code: e.Code}, next}
+}
+
+// errorChain links errors for Unwrap.
+type errorChain struct {
+	err, next error
+}
+
+func (c *errorChain) Error() string { return c.err.Error() }
+
+func (c *errorChain) Is(target error) bool { return errors.Is(c.err, target) }
+
+func (c *errorChain) As(target interface{}) bool { return errors.As(c.err, target) }
+
+func (c *errorChain) Unwrap() error { return c.next }
+
+// Is matches the same Error values and error categories as an Error
+// with the same Code. Errors caused by warnings treated as errors
+// match ErrWarningAsError and ErrCompile.
+func (e *CompileError) Is(target error) bool {
+	if e.promoted {
+		if target == ErrWarningAsError {
+			return true
+		}
+		if t, ok := target.(errorCategory); ok {
+			return t&categoryCompile != 0
+		}
+	}
+	return Error{Code: e.Code}.Is(target)
+}
+
+// source returns the rule text from which a message originated.
+func (e *CompileError) source(filename string) []byte {
+	if buf, ok := e.sources[filename]; ok && buf != nil {
+		return buf
+	}
+	if filename == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	if e.sources == nil {
+		e.sources = make(map[string][]byte)
+	}
+	e.sources[filename] = buf
+	return buf
+}
+
+// Render formats all messages in a way that is similar to the output
+// of the Go compiler: Each message is followed by the offending line
+// of the rule source and up to context lines before and after it.
+//
+//	rules.yar:3: error: rule "foo": syntax error, unexpected identifier
+//	  2 | rule foo {
+//	> 3 |   bar
+//	  4 | }
+//
+// Sources are taken from the strings passed to AddString, from
+// buffers returned by the include callback, or read from the file
+// system.
+func (e *CompileError) Render(context int) string {
+	var b strings.Builder
+	for _, m := range e.Messages {
+		name := m.Filename
+		if name == "" {
+			name = "<string>"
+		}
+		fmt.Fprintf(&b, "%s:%d: %s: %s\n", name, m.Line, m.Severity, m.Text)
+		renderSourceLines(&b, e.source(m.Filename), m.Line, context)
+	}
+	return b.String()
+}
+
+// renderSourceLines writes line (counting from 1) and up to context
+// lines around it from src.
+func renderSourceLines(b *strings.Builder, src []byte, line, context int) {
+	if src == nil || line < 1 {
+		return
+	}
+	lines := bytes.Split(src, []byte("\n"))
+	if line > len(lines) {
+		return
+	}
+	first, last := line-context, line+context
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(fmt.Sprint(last))
+	for i := first; i <= last; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(b, "%s %*d | %s\n", marker, width, i, bytes.TrimRight(lines[i-1], "\r"))
+	}
+}
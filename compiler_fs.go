@@ -0,0 +1,69 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package yara
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// AddFS compiles all files in fsys whose names match pattern (see
+// fs.Glob) in lexical order. Rules are added to the specified
+// namespace. File names within fsys are used in CompilerMessage
+// values.
+//
+// While the files are compiled, include statements are resolved
+// using FSResolver. Afterwards, the previous IncludeResolver or
+// libyara's default include handling is restored. If includes have
+// been disabled using DisableIncludes, they stay disabled.
+//
+// If the rules cannot be compiled, the returned error is a
+// *CompileError. If this function returns an error, the Compiler
+// object will become unusable.
+func (c *Compiler) AddFS(fsys fs.FS, pattern, namespace string) error {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	restore := c.swapIncludeResolver(FSResolver{FS: fsys})
+	defer restore()
+	for _, name := range names {
+		buf, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		if err := c.AddBytes(buf, name, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FSResolver is an IncludeResolver that reads included files from
+// FS. Relative names are resolved relative to the directory of the
+// including file.
+type FSResolver struct {
+	FS fs.FS
+}
+
+// ResolveInclude implements IncludeResolver.
+func (r FSResolver) ResolveInclude(name, filename, _ string) (string, []byte, error) {
+	p := name
+	if filename != "" && !path.IsAbs(name) {
+		p = path.Join(path.Dir(filename), name)
+	}
+	if !fs.ValidPath(p) {
+		return "", nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	buf, err := fs.ReadFile(r.FS, p)
+	return p, buf, err
+}
@@ -0,0 +1,68 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package yara
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompilerAddFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rules/b.yar":          {Data: []byte(`include "inc/common.yar" rule b { condition: common }`)},
+		"rules/a.yar":          {Data: []byte(`rule a { condition: true }`)},
+		"rules/inc/common.yar": {Data: []byte(`include "more.yar" rule common { condition: more }`)},
+		"rules/inc/more.yar":   {Data: []byte(`rule more { condition: true }`)},
+		"rules/readme.txt":     {Data: []byte(`not a rule`)},
+	}
+	c, _ := NewCompiler()
+	if err := c.AddFS(fsys, "rules/*.yar", ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.include != nil {
+		t.Error("libyara's default include handling has not been restored")
+	}
+	r, err := c.GetRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, rule := range r.GetRules() {
+		ids = append(ids, rule.Identifier())
+	}
+	if len(ids) != 4 || ids[0] != "a" {
+		t.Errorf("unexpected rules: %v", ids)
+	}
+	expected := []Include{
+		{Filename: "rules/b.yar", Name: "inc/common.yar", Path: "rules/inc/common.yar", Namespace: "default"},
+		{Filename: "rules/inc/common.yar", Name: "more.yar", Path: "rules/inc/more.yar", Namespace: "default"},
+	}
+	if got := c.Includes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got include graph %+v, expected %+v", got, expected)
+	}
+}
+
+func TestCompilerAddFSError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yar": {Data: []byte(`rule a { condition: true }`)},
+		"b.yar": {Data: []byte("rule b {\n  condition: xyz\n}")},
+	}
+	c, _ := NewCompiler()
+	err := c.AddFS(fsys, "*.yar", "")
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected CompileError, got %v", err)
+	}
+	if len(ce.Messages) == 0 || ce.Messages[0].Filename != "b.yar" || ce.Messages[0].Line != 2 {
+		t.Errorf("unexpected messages: %+v", ce.Messages)
+	}
+}
@@ -7,6 +7,8 @@
 package yara
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -102,3 +104,125 @@ func TestCompilerIncludeCallback(t *testing.T) {
 		t.Fatal(`Compiler did not return error on non-existing include rule`)
 	}
 }
+
+func TestCompilerIncludeCallbackPanic(t *testing.T) {
+	c, err := NewCompiler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetIncludeCallback(func(name, rulefile, namespace string) []byte {
+		panic("include failure")
+	})
+	err = c.AddString(`include "existing"`, "")
+	if pe, ok := err.(*CallbackPanicError); !ok {
+		t.Fatalf("expected CallbackPanicError, got %v", err)
+	} else if pe.Value != "include failure" {
+		t.Errorf("unexpected panic value: %v", pe.Value)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	c, _ := NewCompiler()
+	err := c.AddString("rule foo {\n  bar\n}\n", "")
+	ce, ok := err.(*CompileError)
+	if !ok {
+		t.Fatalf("expected *CompileError, got %#v", err)
+	}
+	if ce.Code != ERROR_SYNTAX_ERROR || !errors.Is(err, ErrSyntaxError) || !errors.Is(err, ErrCompile) {
+		t.Errorf("expected syntax error, got code %d", ce.Code)
+	}
+	if len(ce.Errors()) != 1 || ce.Errors()[0].Line != 2 || ce.Errors()[0].Rule != "foo" {
+		t.Errorf("unexpected messages: %+v", ce.Messages)
+	}
+	var msg CompilerMessage
+	if !errors.As(err, &msg) || msg.Line != 2 {
+		t.Errorf("expected CompilerMessage in chain, got %+v", msg)
+	}
+	if !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("unexpected error text %q", err.Error())
+	}
+	rendered := ce.Render(1)
+	if !strings.Contains(rendered, "> 2 |   bar") || !strings.Contains(rendered, "  1 | rule foo {") {
+		t.Errorf("unexpected rendering:\n%s", rendered)
+	}
+	t.Logf("rendered error:\n%s", rendered)
+}
+
+func TestCompilerAddReader(t *testing.T) {
+	c, _ := NewCompiler()
+	if err := c.AddReader(strings.NewReader("rule a { condition: true }"), "a.yar", ""); err != nil {
+		t.Fatal(err)
+	}
+	err := c.AddReader(strings.NewReader("rule b {\n  condition: xyz\n}"), "b.yar", "")
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected CompileError, got %v", err)
+	}
+	if msgs := ce.Errors(); len(msgs) == 0 || msgs[0].Filename != "b.yar" {
+		t.Errorf("unexpected messages: %+v", ce.Messages)
+	}
+	if !strings.Contains(ce.Render(0), "> 2 |   condition: xyz") {
+		t.Errorf("unexpected rendering:\n%s", ce.Render(0))
+	}
+}
+
+func TestWarningPolicy(t *testing.T) {
+	const slow = `rule slow { strings: $a = "a" condition: $a }`
+	c, _ := NewCompiler()
+	c.SetWarningAction(WarningSlowString, WarningError)
+	if err := c.AddString(slow, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Warnings) != 1 || c.Warnings[0].Category != WarningSlowString {
+		t.Fatalf("unexpected warnings: %+v", c.Warnings)
+	}
+	_, err := c.GetRules()
+	if ce, ok := err.(*CompileError); !ok || len(ce.Errors()) != 1 || ce.Errors()[0].Rule != "slow" {
+		t.Errorf("expected CompileError for promoted warning, got %v", err)
+	}
+	if !errors.Is(err, ErrCompile) || !errors.Is(err, ErrWarningAsError) {
+		t.Errorf("expected promoted warning to match ErrCompile and ErrWarningAsError, got %v", err)
+	}
+	c.SetWarningAction(WarningSlowString, WarningKeep)
+	if r, err := c.GetRules(); err != nil || len(r.GetRules()) != 1 {
+		t.Errorf("expected GetRules to succeed after changing the warning action, got %v", err)
+	}
+
+	c, _ = NewCompiler()
+	c.SetWarningAction(AllWarnings, WarningError)
+	c.SetWarningMetaKey("warnings")
+	if err := c.AddString(`
+		rule slow1 { meta: warnings = "slow-string" strings: $a = "a" condition: $a }
+		rule slow2 { meta: warnings = "all=warn" strings: $a = "b" condition: $a }`, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetRules(); err != nil {
+		t.Error(err)
+	}
+	if len(c.Warnings) != 1 || c.Warnings[0].Rule != "slow2" {
+		t.Errorf("unexpected warnings: %+v", c.Warnings)
+	}
+
+	c, _ = NewCompiler()
+	c.SetWarningAction(WarningSlowString, WarningIgnore)
+	if err := c.AddString(slow, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %+v", c.Warnings)
+	}
+}
+
+func TestClassifyWarning(t *testing.T) {
+	for text, category := range map[string]WarningCategory{
+		`rule "a": string "$a" may slow down scanning`:                                                  WarningSlowString,
+		`rule "a": $a is slowing down scanning`:                                                         WarningSlowString,
+		`rule "a": $a contains .*, consider using .{,N} with a reasonable value for N`:                  WarningSlowRegex,
+		`Using deprecated "entrypoint" keyword. Use the "entry_point" function from PE module instead.`: WarningDeprecated,
+		`something else`: WarningOther,
+	} {
+		if got := classifyWarning(text); got != category {
+			t.Errorf("%s: expected %s, got %s", text, category, got)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// ScanTargetType identifies the Scanner method that has been called.
+type ScanTargetType int
+
+const (
+	// ScanTargetMem is used for ScanMem.
+	ScanTargetMem ScanTargetType = iota
+	// ScanTargetFile is used for ScanFile.
+	ScanTargetFile
+	// ScanTargetFileDescriptor is used for ScanFileDescriptor.
+	ScanTargetFileDescriptor
+)
+
+// ScanTarget describes the data that is about to be scanned. It is
+// passed to ComputedVariable functions.
+type ScanTarget struct {
+	Type ScanTargetType
+	// Data is the buffer passed to ScanMem.
+	Data []byte
+	// Filename is the name passed to ScanFile.
+	Filename string
+	// Fd is the file descriptor passed to ScanFileDescriptor.
+	Fd uintptr
+	// contents is shared among all copies of the ScanTarget.
+	contents *targetContents
+}
+
+type targetContents struct {
+	once sync.Once
+	buf  []byte
+	err  error
+}
+
+// Contents returns the complete data of the target. For files and
+// file descriptors, it is read once and shared among all
+// ComputedVariable functions that are called for the target. The
+// file offset of a file descriptor is not changed. The returned
+// buffer must not be modified.
+func (t ScanTarget) Contents() ([]byte, error) {
+	if t.Type == ScanTargetMem {
+		return t.Data, nil
+	}
+	if t.contents == nil {
+		return t.read()
+	}
+	t.contents.once.Do(func() { t.contents.buf, t.contents.err = t.read() })
+	return t.contents.buf, t.contents.err
+}
+
+func (t ScanTarget) read() ([]byte, error) {
+	if t.Type == ScanTargetFile {
+		return ioutil.ReadFile(t.Filename)
+	}
+	return readFd(t.Fd)
+}
+
+// ComputedVariable is a function that computes the value of an
+// external variable for a scan target. The value must be of a type
+// that is accepted by DefineVariable.
+type ComputedVariable func(target ScanTarget) (interface{}, error)
+
+// SetComputedVariable registers fn to compute the value of the
+// external variable identifier before each call to ScanMem, ScanFile,
+// or ScanFileDescriptor (and their Context variants). fn is called
+// once per scan if the variable is declared in the ruleset. Passing a
+// nil fn removes the registration.
+//
+// If fn returns an error, the scan is not performed and a
+// VariableError is returned.
+func (s *Scanner) SetComputedVariable(identifier string, fn ComputedVariable) *Scanner {
+	if fn == nil {
+		delete(s.computed, identifier)
+		return s
+	}
+	if s.computed == nil {
+		s.computed = make(map[string]ComputedVariable)
+	}
+	s.computed[identifier] = fn
+	return s
+}
+
+// prepareTarget defines file context and computed variables for a
+// scan target.
+func (s *Scanner) prepareTarget(t ScanTarget) error {
+	if t.Type == ScanTargetFile {
+		if err := s.defineFileContext(t.Filename); err != nil {
+			return err
+		}
+	}
+	if len(s.computed) == 0 {
+		return nil
+	}
+	declared := s.declaredVariables()
+	var identifiers []string
+	for identifier := range s.computed {
+		if _, ok := declared[identifier]; ok {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	sort.Strings(identifiers)
+	t.contents = &targetContents{}
+	for _, identifier := range identifiers {
+		value, err := s.computed[identifier](t)
+		if err != nil {
+			return VariableError{Identifier: identifier, Err: err}
+		}
+		if err := s.DefineVariable(identifier, value); err != nil {
+			return VariableError{Identifier: identifier, Err: err}
+		}
+	}
+	return nil
+}
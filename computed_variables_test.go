@@ -0,0 +1,84 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestScannerComputedVariable(t *testing.T) {
+	r, err := Compile(`rule c { condition: double_size == 2 * filesize }`,
+		map[string]interface{}{"double_size": 0, "unused": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScanner(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+	calls := 0
+	s.SetComputedVariable("double_size", func(target ScanTarget) (interface{}, error) {
+		calls++
+		buf, err := target.Contents()
+		return 2 * len(buf), err
+	})
+	s.SetComputedVariable("undeclared", func(target ScanTarget) (interface{}, error) {
+		t.Error("function for undeclared variable called")
+		return nil, nil
+	})
+	unusedCalls := 0
+	s.SetComputedVariable("unused", func(target ScanTarget) (interface{}, error) {
+		unusedCalls++
+		return 0, nil
+	})
+
+	tf, _ := ioutil.TempFile("", "TestScannerComputedVariable")
+	defer os.Remove(tf.Name())
+	tf.Write([]byte("foobar"))
+	defer tf.Close()
+
+	for name, scan := range map[string]func() error{
+		"ScanMem":            func() error { return s.ScanMem([]byte("abc")) },
+		"ScanFile":           func() error { return s.ScanFile(tf.Name()) },
+		"ScanFileDescriptor": func() error { return s.ScanFileDescriptor(tf.Fd()) },
+	} {
+		var m MatchRules
+		s.SetCallback(&m)
+		if err := scan(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if len(m) != 1 {
+			t.Errorf("%s: expected 1 match, got %+v", name, m)
+		}
+	}
+	// Values are computed again for every scan.
+	buf := []byte("abc")
+	for i := 0; i < 2; i++ {
+		if err := s.ScanMem(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 5 || unusedCalls != 5 {
+		t.Errorf("expected 5 calls, got %d, %d", calls, unusedCalls)
+	}
+
+	errTest := errors.New("test")
+	s.SetComputedVariable("double_size", func(target ScanTarget) (interface{}, error) {
+		return nil, errTest
+	})
+	res := &ScanResult{Status: ScanComplete, Matches: MatchRules{{Rule: "stale"}}}
+	var verr VariableError
+	if err := s.SetCallback(res).ScanMem(nil); !errors.As(err, &verr) || verr.Identifier != "double_size" || !errors.Is(err, errTest) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if res.Status != ScanFailed || len(res.Matches) != 0 || res.Err == nil {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
@@ -10,6 +10,7 @@ package yara
 import "C"
 import (
 	"fmt"
+	"runtime/debug"
 )
 
 // Error encapsulates the C API error codes.
@@ -22,6 +23,9 @@ type Error struct {
 	RuleIdentifier string
 	// String in which the error occurred, if applicable. It can be empty.
 	StringIdentifier string
+	// Err contains the error returned by a ScanCallback method if
+	// Code is ERROR_CALLBACK_ERROR. It can be nil.
+	Err error
 }
 
 func (e Error) Error() (errorString string) {
@@ -34,9 +38,199 @@ func (e Error) Error() (errorString string) {
 	} else {
 		errorString = errorCodeToString(e.Code)
 	}
+	if e.Err != nil {
+		errorString += ": " + e.Err.Error()
+	}
 	return errorString
 }
 
+// Unwrap returns the error returned by a ScanCallback method, if
+// any.
+func (e Error) Unwrap() error { return e.Err }
+
+// Is reports whether e matches target. An Error matches another
+// Error with the same Code, regardless of rule and string context,
+// and the error category sentinels (ErrCompile, ErrScan, ErrIO,
+// ErrResourceLimit) that contain its Code.
+func (e Error) Is(target error) bool {
+	switch t := target.(type) {
+	case Error:
+		return e.Code == t.Code
+	case errorCategory:
+		return errorCategories[e.Code]&t != 0
+	}
+	return false
+}
+
+// Sentinel errors that can be used with errors.Is to check for
+// specific YARA error codes.
+var (
+	ErrInsufficientMemory        = Error{Code: ERROR_INSUFFICIENT_MEMORY}
+	ErrCouldNotAttachToProcess   = Error{Code: ERROR_COULD_NOT_ATTACH_TO_PROCESS}
+	ErrCouldNotOpenFile          = Error{Code: ERROR_COULD_NOT_OPEN_FILE}
+	ErrCouldNotMapFile           = Error{Code: ERROR_COULD_NOT_MAP_FILE}
+	ErrInvalidFile               = Error{Code: ERROR_INVALID_FILE}
+	ErrCorruptFile               = Error{Code: ERROR_CORRUPT_FILE}
+	ErrUnsupportedFileVersion    = Error{Code: ERROR_UNSUPPORTED_FILE_VERSION}
+	ErrSyntaxError               = Error{Code: ERROR_SYNTAX_ERROR}
+	ErrDuplicatedIdentifier      = Error{Code: ERROR_DUPLICATED_IDENTIFIER}
+	ErrExecStackOverflow         = Error{Code: ERROR_EXEC_STACK_OVERFLOW}
+	ErrScanTimeout               = Error{Code: ERROR_SCAN_TIMEOUT}
+	ErrTooManyScanThreads        = Error{Code: ERROR_TOO_MANY_SCAN_THREADS}
+	ErrCallbackError             = Error{Code: ERROR_CALLBACK_ERROR}
+	ErrTooManyMatches            = Error{Code: ERROR_TOO_MANY_MATCHES}
+	ErrTooManyREFibers           = Error{Code: ERROR_TOO_MANY_RE_FIBERS}
+	ErrCouldNotReadProcessMemory = Error{Code: ERROR_COULD_NOT_READ_PROCESS_MEMORY}
+	ErrCouldNotReadFile          = Error{Code: ERROR_COULD_NOT_READ_FILE}
+	ErrInvalidModuleData         = Error{Code: ERROR_INVALID_MODULE_DATA}
+	ErrWritingFile               = Error{Code: ERROR_WRITING_FILE}
+	ErrBlockNotReady             = Error{Code: ERROR_BLOCK_NOT_READY}
+)
+
+// errorCategory is a bit set of error categories.
+type errorCategory int
+
+const (
+	categoryCompile errorCategory = 1 << iota
+	categoryScan
+	categoryIO
+	categoryResourceLimit
+)
+
+func (c errorCategory) Error() string {
+	switch c {
+	case categoryCompile:
+		return "compile error"
+	case categoryScan:
+		return "scan error"
+	case categoryIO:
+		return "I/O error"
+	case categoryResourceLimit:
+		return "resource limit exceeded"
+	}
+	return fmt.Sprintf("error category %d", int(c))
+}
+
+// Error categories that can be used with errors.Is to check whether
+// an Error belongs to a group of YARA error codes. A code can belong
+// to more than one category, e.g. ERROR_SCAN_TIMEOUT is both a scan
+// error and a resource limit error.
+var (
+	// ErrCompile matches errors that are caused by rules that
+	// cannot be compiled.
+	ErrCompile error = categoryCompile
+	// ErrScan matches errors that occur while scanning.
+	ErrScan error = categoryScan
+	// ErrIO matches errors that occur while reading or writing
+	// files, compiled rulesets, or process memory.
+	ErrIO error = categoryIO
+	// ErrResourceLimit matches errors that are caused by
+	// exhausted memory, time, or other limits.
+	ErrResourceLimit error = categoryResourceLimit
+)
+
+var errorCategories = map[int]errorCategory{
+	ERROR_INSUFFICIENT_MEMORY:            categoryResourceLimit,
+	ERROR_COULD_NOT_ATTACH_TO_PROCESS:    categoryScan | categoryIO,
+	ERROR_COULD_NOT_OPEN_FILE:            categoryIO,
+	ERROR_COULD_NOT_MAP_FILE:             categoryIO,
+	ERROR_INVALID_FILE:                   categoryIO,
+	ERROR_CORRUPT_FILE:                   categoryIO,
+	ERROR_UNSUPPORTED_FILE_VERSION:       categoryIO,
+	ERROR_INVALID_REGULAR_EXPRESSION:     categoryCompile,
+	ERROR_INVALID_HEX_STRING:             categoryCompile,
+	ERROR_SYNTAX_ERROR:                   categoryCompile,
+	ERROR_LOOP_NESTING_LIMIT_EXCEEDED:    categoryCompile | categoryResourceLimit,
+	ERROR_DUPLICATED_LOOP_IDENTIFIER:     categoryCompile,
+	ERROR_DUPLICATED_IDENTIFIER:          categoryCompile,
+	ERROR_DUPLICATED_TAG_IDENTIFIER:      categoryCompile,
+	ERROR_DUPLICATED_META_IDENTIFIER:     categoryCompile,
+	ERROR_DUPLICATED_STRING_IDENTIFIER:   categoryCompile,
+	ERROR_UNREFERENCED_STRING:            categoryCompile,
+	ERROR_UNDEFINED_STRING:               categoryCompile,
+	ERROR_UNDEFINED_IDENTIFIER:           categoryCompile,
+	ERROR_MISPLACED_ANONYMOUS_STRING:     categoryCompile,
+	ERROR_INCLUDES_CIRCULAR_REFERENCE:    categoryCompile,
+	ERROR_INCLUDE_DEPTH_EXCEEDED:         categoryCompile | categoryResourceLimit,
+	ERROR_WRONG_TYPE:                     categoryCompile,
+	ERROR_EXEC_STACK_OVERFLOW:            categoryScan | categoryResourceLimit,
+	ERROR_SCAN_TIMEOUT:                   categoryScan | categoryResourceLimit,
+	ERROR_TOO_MANY_SCAN_THREADS:          categoryScan | categoryResourceLimit,
+	ERROR_CALLBACK_ERROR:                 categoryScan,
+	ERROR_TOO_MANY_MATCHES:               categoryScan | categoryResourceLimit,
+	ERROR_NESTED_FOR_OF_LOOP:             categoryCompile,
+	ERROR_INVALID_FIELD_NAME:             categoryCompile,
+	ERROR_UNKNOWN_MODULE:                 categoryCompile,
+	ERROR_NOT_A_STRUCTURE:                categoryCompile,
+	ERROR_NOT_INDEXABLE:                  categoryCompile,
+	ERROR_NOT_A_FUNCTION:                 categoryCompile,
+	ERROR_INVALID_FORMAT:                 categoryCompile,
+	ERROR_TOO_MANY_ARGUMENTS:             categoryCompile,
+	ERROR_WRONG_ARGUMENTS:                categoryCompile,
+	ERROR_WRONG_RETURN_TYPE:              categoryCompile,
+	ERROR_DUPLICATED_STRUCTURE_MEMBER:    categoryCompile,
+	ERROR_EMPTY_STRING:                   categoryCompile,
+	ERROR_DIVISION_BY_ZERO:               categoryCompile,
+	ERROR_REGULAR_EXPRESSION_TOO_LARGE:   categoryCompile | categoryResourceLimit,
+	ERROR_TOO_MANY_RE_FIBERS:             categoryScan | categoryResourceLimit,
+	ERROR_COULD_NOT_READ_PROCESS_MEMORY:  categoryScan | categoryIO,
+	ERROR_INVALID_EXTERNAL_VARIABLE_TYPE: categoryCompile,
+	ERROR_REGULAR_EXPRESSION_TOO_COMPLEX: categoryCompile | categoryResourceLimit,
+	ERROR_INVALID_MODULE_NAME:            categoryCompile,
+	ERROR_TOO_MANY_STRINGS:               categoryCompile | categoryResourceLimit,
+	ERROR_INTEGER_OVERFLOW:               categoryCompile,
+	ERROR_CALLBACK_REQUIRED:              categoryScan,
+	ERROR_INVALID_OPERAND:                categoryCompile,
+	ERROR_COULD_NOT_READ_FILE:            categoryIO,
+	ERROR_DUPLICATED_EXTERNAL_VARIABLE:   categoryCompile,
+	ERROR_INVALID_MODULE_DATA:            categoryScan,
+	ERROR_WRITING_FILE:                   categoryIO,
+	ERROR_INVALID_MODIFIER:               categoryCompile,
+	ERROR_DUPLICATED_MODIFIER:            categoryCompile,
+	ERROR_BLOCK_NOT_READY:                categoryScan,
+	ERROR_INVALID_PERCENTAGE:             categoryCompile,
+	ERROR_IDENTIFIER_MATCHES_WILDCARD:    categoryCompile,
+	ERROR_INVALID_VALUE:                  categoryCompile,
+}
+
+// wrapCallbackError attaches cbErr, the error returned by (or the
+// panic that occurred in) a callback function to err if err was
+// caused by the callback.
+func wrapCallbackError(err, cbErr error) error {
+	if e, ok := err.(Error); ok && e.Code == ERROR_CALLBACK_ERROR && e.Err == nil && cbErr != nil {
+		e.Err = cbErr
+		return e
+	}
+	return err
+}
+
+// CallbackPanicError is returned by scan and compile functions if a
+// callback function that is called from libyara (a ScanCallback
+// method, a CompilerIncludeFunc, a MemoryBlockIterator or a
+// MemoryBlock.FetchData function) has panicked. The scan or
+// compilation is aborted in this case.
+type CallbackPanicError struct {
+	// Value is the value passed to panic().
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the
+	// panic, as returned by debug.Stack.
+	Stack []byte
+}
+
+func newCallbackPanicError(value interface{}) *CallbackPanicError {
+	return &CallbackPanicError{Value: value, Stack: debug.Stack()}
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("panic in callback: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic() if it is an error.
+func (e *CallbackPanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 func errorCodeToString(errorCode int) string {
 	if str, ok := errorStrings[errorCode]; ok {
 		return str
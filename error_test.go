@@ -0,0 +1,41 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("scanning: %w", Error{
+		Code:           ERROR_SCAN_TIMEOUT,
+		Namespace:      "default",
+		RuleIdentifier: "slow",
+	})
+	for _, target := range []error{ErrScanTimeout, ErrScan, ErrResourceLimit} {
+		if !errors.Is(err, target) {
+			t.Errorf("expected %v to match %v", err, target)
+		}
+	}
+	for _, target := range []error{ErrTooManyMatches, ErrCompile, ErrIO} {
+		if errors.Is(err, target) {
+			t.Errorf("expected %v not to match %v", err, target)
+		}
+	}
+	var e Error
+	if !errors.As(err, &e) || e.RuleIdentifier != "slow" {
+		t.Errorf("rule context has been lost: %#v", e)
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	if _, err := LoadRules("/nonexistent/rules.yac"); !errors.Is(err, ErrCouldNotOpenFile) || !errors.Is(err, ErrIO) {
+		t.Errorf("LoadRules: expected I/O error, got %v", err)
+	}
+}
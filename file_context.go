@@ -0,0 +1,150 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileContextVariables contains the names of external variables that
+// Scanner.ScanFile sets from the file's path and metadata, see
+// Scanner.SetFileContextVariables. Empty names are ignored.
+type FileContextVariables struct {
+	// Name is set to the file's base name (string).
+	Name string
+	// Path is set to the path as passed to ScanFile (string).
+	Path string
+	// Extension is set to the file name's extension without the
+	// leading dot (string).
+	Extension string
+	// Size is set to the file size (integer).
+	Size string
+	// Mode is set to the file's permission bits (integer).
+	Mode string
+	// UID is set to the owner's user ID (integer). It is 0 on
+	// Windows.
+	UID string
+	// ModTime is set to the modification time as seconds since
+	// the Unix epoch (integer).
+	ModTime string
+	// MIMEType is set to the content type as determined by
+	// http.DetectContentType from the first 512 bytes (string).
+	MIMEType string
+}
+
+// DefaultFileContextVariables contains the variable names that are
+// commonly used with the yara command line tool, plus file_*
+// variables for metadata.
+var DefaultFileContextVariables = FileContextVariables{
+	Name:      "filename",
+	Path:      "filepath",
+	Extension: "extension",
+	Size:      "file_size",
+	Mode:      "file_mode",
+	UID:       "file_uid",
+	ModTime:   "file_mtime",
+	MIMEType:  "filetype",
+}
+
+// SetFileContextVariables makes ScanFile and ScanFileContext define
+// the variables named in v before scanning a file. Only variables
+// that are declared in the ruleset with the type noted in
+// FileContextVariables are defined; the values remain defined after
+// the scan. Values that cannot be determined, e.g. because the file
+// cannot be accessed, are set to the empty string or zero. Passing
+// nil disables this behavior.
+func (s *Scanner) SetFileContextVariables(v *FileContextVariables) *Scanner {
+	if v == nil {
+		s.fileContext = nil
+		return s
+	}
+	declared := s.declaredVariables()
+	check := func(name string, typ VariableType) string {
+		if t, ok := declared[name]; !ok || t != typ {
+			return ""
+		}
+		return name
+	}
+	s.fileContext = &FileContextVariables{
+		Name:      check(v.Name, VariableString),
+		Path:      check(v.Path, VariableString),
+		Extension: check(v.Extension, VariableString),
+		Size:      check(v.Size, VariableInteger),
+		Mode:      check(v.Mode, VariableInteger),
+		UID:       check(v.UID, VariableInteger),
+		ModTime:   check(v.ModTime, VariableInteger),
+		MIMEType:  check(v.MIMEType, VariableString),
+	}
+	return s
+}
+
+// declaredVariables returns the types of the ruleset's external
+// variables.
+func (s *Scanner) declaredVariables() map[string]VariableType {
+	if s.declared == nil {
+		s.declared = make(map[string]VariableType)
+		for _, v := range s.rules.Variables() {
+			s.declared[v.Identifier] = v.Type
+		}
+	}
+	return s.declared
+}
+
+// defineFileContext defines the file context variables for path.
+// The names in s.fileContext have been checked against the ruleset
+// by SetFileContextVariables.
+func (s *Scanner) defineFileContext(path string) error {
+	fc := s.fileContext
+	if fc == nil {
+		return nil
+	}
+	values := make(map[string]interface{})
+	set := func(name string, value interface{}) {
+		if name != "" {
+			values[name] = value
+		}
+	}
+	set(fc.Name, filepath.Base(path))
+	set(fc.Path, path)
+	set(fc.Extension, strings.TrimPrefix(filepath.Ext(path), "."))
+	if fc.Size != "" || fc.Mode != "" || fc.UID != "" || fc.ModTime != "" {
+		// If the file cannot be accessed, the scan will fail.
+		var size, mode, uid, modTime int64
+		if fi, err := os.Stat(path); err == nil {
+			size, mode, modTime = fi.Size(), int64(fi.Mode().Perm()), fi.ModTime().Unix()
+			uid, _ = fileUID(fi)
+		}
+		set(fc.Size, size)
+		set(fc.Mode, mode)
+		set(fc.UID, uid)
+		set(fc.ModTime, modTime)
+	}
+	if fc.MIMEType != "" {
+		mimeType, _ := detectContentType(path)
+		set(fc.MIMEType, mimeType)
+	}
+	return s.DefineVariables(values)
+}
+
+// detectContentType determines the MIME type of a file's contents.
+func detectContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
@@ -0,0 +1,74 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScannerFileContextVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestScannerFileContextVariables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "sample.html")
+	if err := ioutil.WriteFile(filename, []byte("<html><body>x</body></html>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Compile(`
+		rule ctx {
+			condition:
+				filename == "sample.html" and extension == "html" and
+				file_size == 27 and file_mode == 0x180 and
+				filetype startswith "text/html"
+		}`,
+		map[string]interface{}{
+			"filename": "", "extension": "", "file_size": 0, "file_mode": 0, "filetype": "",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScanner(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+	var m MatchRules
+	if err := s.SetCallback(&m).ScanFile(filename); err != nil {
+		t.Fatal(err)
+	} else if len(m) != 0 {
+		t.Errorf("expected no match without file context, got %+v", m)
+	}
+	m = nil
+	s.SetFileContextVariables(&DefaultFileContextVariables)
+	if err := s.ScanFile(filename); err != nil {
+		t.Fatal(err)
+	} else if len(m) != 1 {
+		t.Errorf("expected 1 match, got %+v", m)
+	}
+	// Variables whose declared type does not match are ignored.
+	r, err = Compile(`rule ctx { condition: filename == 0 }`, map[string]interface{}{"filename": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewScanner(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+	m = nil
+	s.SetFileContextVariables(&DefaultFileContextVariables)
+	if err := s.SetCallback(&m).ScanFile(filename); err != nil {
+		t.Fatal(err)
+	} else if len(m) != 1 {
+		t.Errorf("expected 1 match, got %+v", m)
+	}
+}
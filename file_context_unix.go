@@ -0,0 +1,41 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package yara
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileUID(fi os.FileInfo) (int64, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Uid), true
+	}
+	return 0, false
+}
+
+// readFd reads the contents of a file descriptor without changing
+// its offset.
+func readFd(fd uintptr) ([]byte, error) {
+	var buf []byte
+	chunk := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Pread(int(fd), chunk, int64(len(buf)))
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return nil, &os.PathError{Op: "pread", Path: "fd", Err: err}
+		}
+		if n == 0 {
+			return buf, nil
+		}
+		buf = append(buf, chunk[:n]...)
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"os"
+)
+
+func fileUID(fi os.FileInfo) (int64, bool) { return 0, false }
+
+func readFd(fd uintptr) ([]byte, error) {
+	return nil, errors.New("reading file descriptors is not supported on Windows")
+}
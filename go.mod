@@ -1,3 +1,3 @@
 module github.com/hillu/go-yara/v4
 
-go 1.10
+go 1.13
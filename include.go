@@ -0,0 +1,120 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// An IncludeResolver provides the contents of files that are
+// referred to by include statements.
+//
+// ResolveInclude is called with the name from the include statement,
+// the path of the file that contains the include statement (empty
+// for rules passed as string without a file name) and the current
+// namespace. It returns the path that identifies the included file
+// and its contents. The path is passed as filename for include
+// statements within the included file and is recorded in the
+// Compiler's include graph.
+//
+// A non-nil error is reported as a CompilerMessage at the location of
+// the include statement.
+type IncludeResolver interface {
+	ResolveInclude(name, filename, namespace string) (path string, content []byte, err error)
+}
+
+// An Include records an include statement that has been resolved by
+// the Compiler.
+type Include struct {
+	// Filename is the path of the file that contains the include
+	// statement.
+	Filename string
+	// Name is the name used in the include statement.
+	Name string
+	// Path is the path of the included file, as returned by the
+	// IncludeResolver.
+	Path      string
+	Namespace string
+}
+
+// SearchPath is an IncludeResolver that reads included files from the
+// file system. Relative names are looked up in the directory of the
+// including file (or in the current directory if rules have not been
+// read from a file), then in each of the listed directories.
+//
+// SearchPath(nil) behaves like YARA's default include handling, but
+// the include graph is recorded, see Compiler.Includes.
+type SearchPath []string
+
+// ResolveInclude implements IncludeResolver.
+func (sp SearchPath) ResolveInclude(name, filename, _ string) (string, []byte, error) {
+	if filepath.IsAbs(name) {
+		buf, err := ioutil.ReadFile(name)
+		return name, buf, err
+	}
+	candidates := []string{name}
+	if filename != "" {
+		candidates[0] = filepath.Join(filepath.Dir(filename), name)
+	}
+	for _, dir := range sp {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	for _, p := range candidates {
+		buf, err := ioutil.ReadFile(p)
+		if err == nil {
+			return p, buf, nil
+		} else if !os.IsNotExist(err) {
+			return p, nil, err
+		}
+	}
+	return "", nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// ErrIncludeNotAllowed is returned by AllowList for include statements
+// that have been rejected.
+var ErrIncludeNotAllowed = errors.New("include not allowed")
+
+// AllowList is an IncludeResolver that passes include statements on
+// to Resolver only if the name is a relative path that does not
+// refer to the including file's parent directory using "..". If
+// Patterns is not empty, the name must also match one of them (see
+// path.Match).
+type AllowList struct {
+	Resolver IncludeResolver
+	Patterns []string
+}
+
+// ResolveInclude implements IncludeResolver.
+func (a AllowList) ResolveInclude(name, filename, namespace string) (string, []byte, error) {
+	slashed := filepath.ToSlash(name)
+	if path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", nil, fmt.Errorf("%w: %s: absolute path", ErrIncludeNotAllowed, name)
+	}
+	clean := path.Clean(slashed)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", nil, fmt.Errorf("%w: %s: refers to parent directory", ErrIncludeNotAllowed, name)
+	}
+	if len(a.Patterns) > 0 {
+		var matched bool
+		for _, pattern := range a.Patterns {
+			if ok, _ := path.Match(pattern, clean); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "", nil, fmt.Errorf("%w: %s: does not match any pattern", ErrIncludeNotAllowed, name)
+		}
+	}
+	return a.Resolver.ResolveInclude(name, filename, namespace)
+}
@@ -0,0 +1,111 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-yara-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib")
+	os.Mkdir(lib, 0755)
+	ioutil.WriteFile(filepath.Join(lib, "common.yar"), []byte(`include "sub.yar" rule common { condition: sub }`), 0644)
+	ioutil.WriteFile(filepath.Join(lib, "sub.yar"), []byte(`rule sub { condition: true }`), 0644)
+
+	c, _ := NewCompiler()
+	c.SetIncludeResolver(SearchPath{lib})
+	if err := c.AddBytes([]byte(`include "common.yar" rule main { condition: common }`), "main.yar", ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Include{
+		{Filename: "main.yar", Name: "common.yar", Path: filepath.Join(lib, "common.yar"), Namespace: "default"},
+		{Filename: filepath.Join(lib, "common.yar"), Name: "sub.yar", Path: filepath.Join(lib, "sub.yar"), Namespace: "default"},
+	}
+	if got := c.Includes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got include graph %+v, expected %+v", got, expected)
+	}
+}
+
+func TestIncludeSameName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-yara-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"a/common.yar": `include "../b/y.yar" include "leaf.yar"`,
+		"a/leaf.yar":   `rule leaf_a { condition: true }`,
+		"b/y.yar":      `include "common.yar"`,
+		"b/common.yar": `include "leaf.yar"`,
+		"b/leaf.yar":   `rule leaf_b { condition: true }`,
+	} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	c, _ := NewCompiler()
+	c.SetIncludeResolver(SearchPath(nil))
+	if err := c.AddBytes([]byte(`include "a/common.yar"`), filepath.Join(dir, "main.yar"), ""); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, inc := range c.Includes() {
+		rel, _ := filepath.Rel(dir, inc.Path)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	expected := []string{"a/common.yar", "b/y.yar", "b/common.yar", "b/leaf.yar", "a/leaf.yar"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got %v, expected %v", paths, expected)
+	}
+}
+
+func TestIncludeResolverError(t *testing.T) {
+	c, _ := NewCompiler()
+	c.SetIncludeResolver(AllowList{Resolver: SearchPath(nil), Patterns: []string{"*.yar"}})
+	err := c.AddString("rule a { condition: true }\ninclude \"../secret.yar\"\n", "")
+	ce, ok := err.(*CompileError)
+	if !ok {
+		t.Fatalf("expected *CompileError, got %v", err)
+	}
+	msgs := ce.Errors()
+	if len(msgs) == 0 || msgs[0].Line != 2 || !errors.Is(msgs[0].Err, ErrIncludeNotAllowed) ||
+		!strings.Contains(msgs[0].Text, "parent directory") {
+		t.Errorf("unexpected messages: %+v", ce.Messages)
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	found := CompilerIncludeFunc(func(name, _, _ string) []byte { return []byte{} })
+	a := AllowList{Resolver: found, Patterns: []string{"rules/*.yar"}}
+	for name, allowed := range map[string]bool{
+		"rules/a.yar":            true,
+		"rules/x/../b.yar":       true,
+		"/etc/passwd":            false,
+		"../rules/a.yar":         false,
+		"rules/../../x.yar":      false,
+		"other/a.yar":            false,
+		"rules/a.txt":            false,
+		filepath.Join("..", "x"): false,
+	} {
+		_, _, err := a.ResolveInclude(name, "", "")
+		if allowed && err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if !allowed && !errors.Is(err, ErrIncludeNotAllowed) {
+			t.Errorf("%s: expected ErrIncludeNotAllowed, got %v", name, err)
+		}
+	}
+}
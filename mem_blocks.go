@@ -20,6 +20,7 @@ uint64_t memoryBlockIteratorFilesize(YR_MEMORY_BLOCK_ITERATOR*);
 */
 import "C"
 import (
+	"context"
 	"reflect"
 	"unsafe"
 )
@@ -49,6 +50,12 @@ type memoryBlockIteratorContainer struct {
 	// YARA. Its backing array lives in malloc memory and will only be
 	// resized using the realloc method.
 	buf []byte
+	// err records a panic that occurred in one of the iterator
+	// methods or in FetchData, or the error of a cancelled ctx.
+	err error
+	// ctx is checked for cancellation before every block. It can
+	// be nil.
+	ctx context.Context
 }
 
 func makeMemoryBlockIteratorContainer(mbi MemoryBlockIterator) (c *memoryBlockIteratorContainer) {
@@ -109,8 +116,16 @@ type MemoryBlock struct {
 // It is called from YARA code.
 //
 //export memoryBlockFetch
-func memoryBlockFetch(cblock *C.YR_MEMORY_BLOCK) *C.uint8_t {
+func memoryBlockFetch(cblock *C.YR_MEMORY_BLOCK) (data *C.uint8_t) {
 	c := ((*cgoHandle)(cblock.context)).Value().(*memoryBlockIteratorContainer)
+	// A panic causes the block to be skipped; the scan is aborted
+	// by the next call to memoryBlockIteratorNext.
+	defer func() {
+		if p := recover(); p != nil {
+			c.err = newCallbackPanicError(p)
+			data = nil
+		}
+	}()
 	c.realloc(int(cblock.size))
 	c.MemoryBlock.FetchData(c.buf)
 	return (*C.uint8_t)(unsafe.Pointer(&c.buf[0]))
@@ -140,12 +155,29 @@ func memoryBlockIteratorCommon(cmbi *C.YR_MEMORY_BLOCK_ITERATOR, c *memoryBlockI
 	return
 }
 
+// recoverIterator is deferred by memoryBlockIteratorFirst and
+// memoryBlockIteratorNext. It records a panic or the cancellation of
+// the scan's context and makes libyara abort the scan.
+func (c *memoryBlockIteratorContainer) recoverIterator(cmbi *C.YR_MEMORY_BLOCK_ITERATOR, cblock **C.YR_MEMORY_BLOCK) {
+	if p := recover(); p != nil {
+		c.err = newCallbackPanicError(p)
+	}
+	if c.err == nil && c.ctx != nil {
+		c.err = c.ctx.Err()
+	}
+	if c.err != nil {
+		cmbi.last_error = C.ERROR_CALLBACK_ERROR
+		*cblock = nil
+	}
+}
+
 // memoryBlockIteratorFirst is used as YR_MEMORY_BLOCK_ITERATOR.first.
 // It is called from YARA code.
 //
 //export memoryBlockIteratorFirst
-func memoryBlockIteratorFirst(cmbi *C.YR_MEMORY_BLOCK_ITERATOR) *C.YR_MEMORY_BLOCK {
+func memoryBlockIteratorFirst(cmbi *C.YR_MEMORY_BLOCK_ITERATOR) (cblock *C.YR_MEMORY_BLOCK) {
 	c := ((*cgoHandle)(cmbi.context)).Value().(*memoryBlockIteratorContainer)
+	defer c.recoverIterator(cmbi, &cblock)
 	c.MemoryBlock = c.MemoryBlockIterator.First()
 	return memoryBlockIteratorCommon(cmbi, c)
 }
@@ -154,14 +186,24 @@ func memoryBlockIteratorFirst(cmbi *C.YR_MEMORY_BLOCK_ITERATOR) *C.YR_MEMORY_BLO
 // It is called from YARA code.
 //
 //export memoryBlockIteratorNext
-func memoryBlockIteratorNext(cmbi *C.YR_MEMORY_BLOCK_ITERATOR) *C.YR_MEMORY_BLOCK {
+func memoryBlockIteratorNext(cmbi *C.YR_MEMORY_BLOCK_ITERATOR) (cblock *C.YR_MEMORY_BLOCK) {
 	c := ((*cgoHandle)(cmbi.context)).Value().(*memoryBlockIteratorContainer)
+	defer c.recoverIterator(cmbi, &cblock)
+	if c.err != nil {
+		return
+	}
 	c.MemoryBlock = c.MemoryBlockIterator.Next()
 	return memoryBlockIteratorCommon(cmbi, c)
 }
 
 //export memoryBlockIteratorFilesize
-func memoryBlockIteratorFilesize(cmbi *C.YR_MEMORY_BLOCK_ITERATOR) C.uint64_t {
+func memoryBlockIteratorFilesize(cmbi *C.YR_MEMORY_BLOCK_ITERATOR) (size C.uint64_t) {
 	c := ((*cgoHandle)(cmbi.context)).Value().(*memoryBlockIteratorContainer)
+	defer func() {
+		if p := recover(); p != nil {
+			c.err = newCallbackPanicError(p)
+			size = C.YR_UNDEFINED
+		}
+	}()
 	return C.uint64_t(c.MemoryBlockIterator.(MemoryBlockIteratorWithFilesize).Filesize())
 }
@@ -146,3 +146,30 @@ rule t1 { condition: math.entropy(0, 10) < 0.5 }
 		t.Logf("simple iterator scan (aaa..bbb): %+v", mrs)
 	}
 }
+
+func TestIteratorFetchPanic(t *testing.T) {
+	rs := MustCompile(`rule t1 { condition: true }`, nil)
+	var mrs MatchRules
+	err := rs.ScanMemBlocks(&panicFetchIter{&testIter{
+		data: []block{{0, []byte("aaaaaaaaaaaaaaaa")}},
+	}}, 0, 0, &mrs)
+	if pe, ok := err.(Error); !ok {
+		t.Fatalf("expected Error, got %#v", err)
+	} else if _, ok := pe.Err.(*CallbackPanicError); !ok {
+		t.Fatalf("expected CallbackPanicError, got %#v", pe.Err)
+	}
+	t.Logf("got expected error: %v", err)
+}
+
+type panicFetchIter struct{ *testIter }
+
+func (it *panicFetchIter) First() *MemoryBlock { return it.wrap(it.testIter.First()) }
+
+func (it *panicFetchIter) Next() *MemoryBlock { return it.wrap(it.testIter.Next()) }
+
+func (it *panicFetchIter) wrap(mb *MemoryBlock) *MemoryBlock {
+	if mb != nil {
+		mb.FetchData = func([]byte) { panic("fetch failure") }
+	}
+	return mb
+}
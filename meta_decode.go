@@ -0,0 +1,323 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownMeta is returned (wrapped in a MetaError) by
+	// DecodeMetas for metas that do not correspond to a field.
+	ErrUnknownMeta = errors.New("unknown meta")
+	// ErrMissingMeta is returned (wrapped in a MetaError) by
+	// DecodeMetas for required metas that are not present.
+	ErrMissingMeta = errors.New("missing required meta")
+	// ErrRepeatedMeta is returned (wrapped in a MetaError) by
+	// DecodeMetas if a meta occurs several times but the field is
+	// not a slice.
+	ErrRepeatedMeta = errors.New("repeated meta")
+)
+
+// A MetaError describes a problem with a single meta.
+type MetaError struct {
+	Identifier string
+	Err        error
+}
+
+func (e MetaError) Error() string { return fmt.Sprintf("meta %s: %v", e.Identifier, e.Err) }
+
+func (e MetaError) Unwrap() error { return e.Err }
+
+// MetaErrors is returned by DecodeMetas.
+type MetaErrors []MetaError
+
+func (errs MetaErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// MetaTimeLayouts contains the layouts that are tried when a string
+// meta is decoded into a time.Time field that has no layout option.
+var MetaTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+	"2006.01.02",
+	"02.01.2006",
+	"2006-01",
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	metaSliceType       = reflect.TypeOf([]Meta(nil))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// metaField describes a struct field that metas are decoded into.
+type metaField struct {
+	index    int
+	required bool
+	layout   string
+	count    int
+}
+
+// DecodeMetas stores metas in the struct pointed to by v, similar to
+// json.Unmarshal. Fields are associated with metas using struct tags:
+//
+//	type ruleInfo struct {
+//		Author     string    `yara:"author,required"`
+//		References []string  `yara:"reference"`
+//		Date       time.Time `yara:"date,layout=2006-01-02"`
+//		Score      int       `yara:"score"`
+//		Other      []Meta    `yara:"*"`
+//	}
+//
+// Fields without tag are ignored. Metas that occur several times can
+// be decoded into slice fields. Strings are parsed for numeric,
+// boolean, and time.Time fields; time.Time fields are parsed using
+// the layout option or MetaTimeLayouts, integer metas are interpreted
+// as Unix timestamps. Fields of type interface{} receive the meta
+// value as-is, fields whose pointer type implements
+// encoding.TextUnmarshaler receive string metas.
+//
+// Metas that do not correspond to a field are collected in a []Meta
+// field tagged "*" if present and otherwise reported as
+// ErrUnknownMeta. All problems are reported as MetaErrors, the
+// remaining metas are decoded nevertheless.
+func DecodeMetas(metas []Meta, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported type %T, expected pointer to struct", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	fields := make(map[string]*metaField)
+	var ids []string
+	remain := -1
+	for i := 0; i < rt.NumField(); i++ {
+		tag := rt.Field(i).Tag.Get("yara")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		if opts[0] == "*" {
+			if rt.Field(i).Type != metaSliceType {
+				return fmt.Errorf("field %s: %q requires type []Meta", rt.Field(i).Name, tag)
+			}
+			remain = i
+			continue
+		}
+		f := &metaField{index: i}
+		for _, opt := range opts[1:] {
+			switch {
+			case opt == "required":
+				f.required = true
+			case strings.HasPrefix(opt, "layout="):
+				f.layout = strings.TrimPrefix(opt, "layout=")
+			default:
+				return fmt.Errorf("field %s: unknown option %q", rt.Field(i).Name, opt)
+			}
+		}
+		fields[opts[0]] = f
+		ids = append(ids, opts[0])
+	}
+	var errs MetaErrors
+	for _, m := range metas {
+		f, ok := fields[m.Identifier]
+		if !ok {
+			if remain >= 0 {
+				rf := rv.Field(remain)
+				rf.Set(reflect.Append(rf, reflect.ValueOf(m)))
+			} else {
+				errs = append(errs, MetaError{m.Identifier, ErrUnknownMeta})
+			}
+			continue
+		}
+		f.count++
+		fv := rv.Field(f.index)
+		var err error
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			if f.count == 1 {
+				fv.Set(reflect.Zero(fv.Type()))
+			}
+			elem := reflect.New(fv.Type().Elem()).Elem()
+			if err = setMetaValue(elem, m.Value, f.layout); err == nil {
+				fv.Set(reflect.Append(fv, elem))
+			}
+		} else if f.count > 1 {
+			err = ErrRepeatedMeta
+		} else {
+			err = setMetaValue(fv, m.Value, f.layout)
+		}
+		if err != nil {
+			errs = append(errs, MetaError{m.Identifier, err})
+		}
+	}
+	for _, id := range ids {
+		if f := fields[id]; f.required && f.count == 0 {
+			errs = append(errs, MetaError{id, ErrMissingMeta})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// setMetaValue converts a meta value (string, int, bool, or nil) for
+// storage in v.
+func setMetaValue(v reflect.Value, value interface{}, layout string) error {
+	if value == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setMetaValue(v.Elem(), value, layout)
+	}
+	s, isString := value.(string)
+	if isString && v.Type() != timeType && v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if v.Type() == timeType {
+		switch value := value.(type) {
+		case int:
+			v.Set(reflect.ValueOf(time.Unix(int64(value), 0).UTC()))
+			return nil
+		case string:
+			t, err := parseMetaTime(value, layout)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(value))
+			return nil
+		}
+	case reflect.String:
+		if isString {
+			v.SetString(s)
+			return nil
+		}
+	case reflect.Slice:
+		if isString && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+	case reflect.Bool:
+		switch value := value.(type) {
+		case bool:
+			v.SetBool(value)
+			return nil
+		case int:
+			v.SetBool(value != 0)
+			return nil
+		case string:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch value := value.(type) {
+		case int:
+			i = int64(value)
+		case string:
+			var err error
+			if i, err = strconv.ParseInt(strings.TrimSpace(value), 0, 64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot decode %T into %s", value, v.Type())
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("value %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch value := value.(type) {
+		case int:
+			if value < 0 {
+				return fmt.Errorf("value %d overflows %s", value, v.Type())
+			}
+			u = uint64(value)
+		case string:
+			var err error
+			if u, err = strconv.ParseUint(strings.TrimSpace(value), 0, 64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot decode %T into %s", value, v.Type())
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("value %d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch value := value.(type) {
+		case int:
+			v.SetFloat(float64(value))
+			return nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(value), v.Type().Bits())
+			if err != nil {
+				return err
+			}
+			v.SetFloat(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot decode %T into %s", value, v.Type())
+}
+
+// parseMetaTime parses s using layout or, if layout is empty,
+// MetaTimeLayouts.
+func parseMetaTime(s, layout string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if layout != "" {
+		return time.Parse(layout, s)
+	}
+	for _, layout := range MetaTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as time", s)
+}
+
+// DecodeMetas stores the rule's metas in the struct pointed to by v,
+// see DecodeMetas.
+func (r *Rule) DecodeMetas(v interface{}) error { return DecodeMetas(r.Metas(), v) }
+
+// DecodeMetas stores the matching rule's metas in the struct pointed
+// to by v, see DecodeMetas.
+func (mr *MatchRule) DecodeMetas(v interface{}) error { return DecodeMetas(mr.Metas, v) }
@@ -0,0 +1,90 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeMetas(t *testing.T) {
+	type ruleInfo struct {
+		Author     string      `yara:"author,required"`
+		References []string    `yara:"reference"`
+		Date       time.Time   `yara:"date"`
+		Modified   time.Time   `yara:"modified,layout=02/01/2006"`
+		Score      uint8       `yara:"score"`
+		Version    float64     `yara:"version"`
+		Enabled    *bool       `yara:"enabled"`
+		Host       net.IP      `yara:"host"`
+		Raw        interface{} `yara:"raw"`
+		Other      []Meta      `yara:"*"`
+		Ignored    string
+	}
+	mr := MatchRule{Metas: []Meta{
+		{"author", "Jane Doe"},
+		{"reference", "https://example.com/1"},
+		{"reference", "https://example.com/2"},
+		{"date", "2021-03-04"},
+		{"modified", "05/06/2022"},
+		{"score", "0x50"},
+		{"version", 2},
+		{"enabled", true},
+		{"host", "192.0.2.1"},
+		{"raw", 17},
+		{"tlp", "white"},
+	}}
+	var info ruleInfo
+	if err := mr.DecodeMetas(&info); err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	expected := ruleInfo{
+		Author:     "Jane Doe",
+		References: []string{"https://example.com/1", "https://example.com/2"},
+		Date:       time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		Modified:   time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC),
+		Score:      80,
+		Version:    2,
+		Enabled:    &enabled,
+		Host:       net.ParseIP("192.0.2.1"),
+		Raw:        17,
+		Other:      []Meta{{"tlp", "white"}},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+
+	type strict struct {
+		Author string `yara:"author,required"`
+		Score  int8   `yara:"score"`
+		Date   string `yara:"date"`
+	}
+	var s strict
+	err := DecodeMetas([]Meta{{"score", 300}, {"date", "a"}, {"date", "b"}, {"x", 1}}, &s)
+	errs, ok := err.(MetaErrors)
+	if !ok || len(errs) != 4 {
+		t.Fatalf("expected 4 errors, got %v", err)
+	}
+	for i, exp := range []struct {
+		id  string
+		err error
+	}{{"score", nil}, {"date", ErrRepeatedMeta}, {"x", ErrUnknownMeta}, {"author", ErrMissingMeta}} {
+		if errs[i].Identifier != exp.id || exp.err != nil && !errors.Is(errs[i], exp.err) {
+			t.Errorf("error %d: expected %s/%v, got %v", i, exp.id, exp.err, errs[i])
+		}
+	}
+	if s.Date != "a" {
+		t.Errorf("expected first date to be kept, got %q", s.Date)
+	}
+	if err := DecodeMetas(nil, s); err == nil {
+		t.Error("expected error for non-pointer")
+	}
+}
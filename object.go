@@ -7,8 +7,483 @@
 package yara
 
 /*
+#include <math.h>
 #include <yara.h>
+
+// object_type is an accessor function.
+// (CGO maps the type field to _type which is not portable.)
+static int object_type(YR_OBJECT* o) {
+	return o->type;
+}
+
+// object_identifier is an accessor function.
+static const char* object_identifier(YR_OBJECT* o) {
+	return o->identifier;
+}
+
+// object_parent is an accessor function.
+static YR_OBJECT* object_parent(YR_OBJECT* o) {
+	return o->parent;
+}
+
+// object_is_undefined uses the same semantics as
+// yr_object_has_undefined_value.
+static int object_is_undefined(YR_OBJECT* o) {
+	switch (o->type) {
+	case OBJECT_TYPE_FLOAT:
+		return isnan(o->value.d);
+	case OBJECT_TYPE_STRING:
+		return o->value.ss == NULL;
+	case OBJECT_TYPE_INTEGER:
+		return o->value.i == YR_UNDEFINED;
+	}
+	return 0;
+}
+
+// object_integer is a union accessor function.
+static int64_t object_integer(YR_OBJECT* o) {
+	return o->value.i;
+}
+
+// object_float is a union accessor function.
+static double object_float(YR_OBJECT* o) {
+	return o->value.d;
+}
+
+// object_string is a union accessor function.
+static const char* object_string(YR_OBJECT* o, uint32_t* length) {
+	SIZED_STRING* ss = o->value.ss;
+	if (ss == NULL) {
+		*length = 0;
+		return NULL;
+	}
+	*length = ss->length;
+	return ss->c_string;
+}
+
+// object_structure_members returns pointers to the members of a
+// structure object.
+static void object_structure_members(YR_OBJECT* o, YR_OBJECT* members[], int *n) {
+	YR_STRUCTURE_MEMBER* member = ((YR_OBJECT_STRUCTURE*)o)->members;
+	int i = 0;
+	for (; member != NULL; member = member->next) {
+		if (i < *n)
+			members[i] = member->object;
+		i++;
+	}
+	*n = i;
+	return;
+}
+
+// object_array_length returns the number of items in an array
+// object.
+static int object_array_length(YR_OBJECT* o) {
+	YR_ARRAY_ITEMS* items = ((YR_OBJECT_ARRAY*)o)->items;
+	return items == NULL ? 0 : items->length;
+}
+
+// object_array_item returns an array item, it may be NULL.
+static YR_OBJECT* object_array_item(YR_OBJECT* o, int i) {
+	YR_ARRAY_ITEMS* items = ((YR_OBJECT_ARRAY*)o)->items;
+	if (items == NULL || i < 0 || i >= items->length)
+		return NULL;
+	return items->objects[i];
+}
+
+// object_dictionary_length returns the number of items in a
+// dictionary object.
+static int object_dictionary_length(YR_OBJECT* o) {
+	YR_DICTIONARY_ITEMS* items = ((YR_OBJECT_DICTIONARY*)o)->items;
+	return items == NULL ? 0 : items->used;
+}
+
+// object_dictionary_item returns key and value for a dictionary item.
+static YR_OBJECT* object_dictionary_item(YR_OBJECT* o, int i, const char** key, uint32_t* length) {
+	YR_DICTIONARY_ITEMS* items = ((YR_OBJECT_DICTIONARY*)o)->items;
+	if (items == NULL || i < 0 || i >= items->used)
+		return NULL;
+	*key = items->objects[i].key->c_string;
+	*length = items->objects[i].key->length;
+	return items->objects[i].obj;
+}
 */
 import "C"
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+)
 
+// Object represents a value that is part of the data structure
+// exposed by a YARA module, such as the module object passed to the
+// ScanCallbackModuleImportFinished.ModuleImported method.
+//
+// The underlying YR_OBJECT structure is owned by the scan context.
+// It (and every Object derived from it) must not be used after the
+// callback method that received it has returned.
 type Object struct{ cptr *C.YR_OBJECT }
+
+// ObjectType describes the type of an Object.
+type ObjectType int
+
+const (
+	ObjectTypeInteger    ObjectType = C.OBJECT_TYPE_INTEGER
+	ObjectTypeString     ObjectType = C.OBJECT_TYPE_STRING
+	ObjectTypeStructure  ObjectType = C.OBJECT_TYPE_STRUCTURE
+	ObjectTypeArray      ObjectType = C.OBJECT_TYPE_ARRAY
+	ObjectTypeFunction   ObjectType = C.OBJECT_TYPE_FUNCTION
+	ObjectTypeDictionary ObjectType = C.OBJECT_TYPE_DICTIONARY
+	ObjectTypeFloat      ObjectType = C.OBJECT_TYPE_FLOAT
+)
+
+func (t ObjectType) String() string {
+	switch t {
+	case ObjectTypeInteger:
+		return "integer"
+	case ObjectTypeString:
+		return "string"
+	case ObjectTypeStructure:
+		return "structure"
+	case ObjectTypeArray:
+		return "array"
+	case ObjectTypeFunction:
+		return "function"
+	case ObjectTypeDictionary:
+		return "dictionary"
+	case ObjectTypeFloat:
+		return "float"
+	}
+	return fmt.Sprintf("unknown object type %d", int(t))
+}
+
+// undefinedValue is the type of Undefined.
+type undefinedValue struct{}
+
+func (undefinedValue) String() string { return "undefined" }
+
+// Undefined is returned by (*Object).Value for integer, float, and
+// string objects that have not been set by the module.
+var Undefined = undefinedValue{}
+
+var (
+	// ErrObjectNotFound is returned by (*Object).Get if a path
+	// element does not exist.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrObjectType is returned by (*Object).Get if a path element
+	// is applied to an object of the wrong type.
+	ErrObjectType = errors.New("wrong object type")
+)
+
+// Type returns the object's type.
+func (o *Object) Type() ObjectType {
+	t := ObjectType(C.object_type(o.cptr))
+	runtime.KeepAlive(o)
+	return t
+}
+
+// Identifier returns the object's name. Array and dictionary items
+// have no identifier.
+func (o *Object) Identifier() string {
+	var id string
+	if cid := C.object_identifier(o.cptr); cid != nil {
+		id = C.GoString(cid)
+	}
+	runtime.KeepAlive(o)
+	return id
+}
+
+// Parent returns the structure, array, or dictionary object that
+// contains the object. It returns nil for the module's root object.
+func (o *Object) Parent() *Object {
+	var p *Object
+	if ptr := C.object_parent(o.cptr); ptr != nil {
+		p = &Object{ptr}
+	}
+	runtime.KeepAlive(o)
+	return p
+}
+
+// IsUndefined returns true if the object is an integer, float, or
+// string object whose value has not been set.
+func (o *Object) IsUndefined() bool {
+	undef := C.object_is_undefined(o.cptr) != 0
+	runtime.KeepAlive(o)
+	return undef
+}
+
+// Integer returns the value of an integer object. The second return
+// value is false if the object is not an integer or if it is
+// undefined.
+func (o *Object) Integer() (int64, bool) {
+	if o.Type() != ObjectTypeInteger || o.IsUndefined() {
+		return 0, false
+	}
+	i := int64(C.object_integer(o.cptr))
+	runtime.KeepAlive(o)
+	return i, true
+}
+
+// Float returns the value of a float object. The second return value
+// is false if the object is not a float or if it is undefined.
+func (o *Object) Float() (float64, bool) {
+	if o.Type() != ObjectTypeFloat || o.IsUndefined() {
+		return 0, false
+	}
+	f := float64(C.object_float(o.cptr))
+	runtime.KeepAlive(o)
+	return f, true
+}
+
+// Bytes returns a copy of the value of a string object. The second
+// return value is false if the object is not a string or if it is
+// undefined.
+func (o *Object) Bytes() ([]byte, bool) {
+	if o.Type() != ObjectTypeString || o.IsUndefined() {
+		return nil, false
+	}
+	var length C.uint32_t
+	ptr := C.object_string(o.cptr, &length)
+	buf := C.GoBytes(unsafe.Pointer(ptr), C.int(length))
+	runtime.KeepAlive(o)
+	return buf, true
+}
+
+// Value returns the value of an integer, float, or string object as
+// int64, float64, or []byte, respectively. Undefined is returned for
+// values that have not been set. For structures, arrays,
+// dictionaries, and functions, nil is returned.
+func (o *Object) Value() interface{} {
+	switch o.Type() {
+	case ObjectTypeInteger, ObjectTypeFloat, ObjectTypeString:
+	default:
+		return nil
+	}
+	if o.IsUndefined() {
+		return Undefined
+	}
+	switch o.Type() {
+	case ObjectTypeInteger:
+		i, _ := o.Integer()
+		return i
+	case ObjectTypeFloat:
+		f, _ := o.Float()
+		return f
+	default:
+		buf, _ := o.Bytes()
+		return buf
+	}
+}
+
+// Members returns the members of a structure object. For other
+// object types, nil is returned.
+func (o *Object) Members() (members []*Object) {
+	if o.Type() != ObjectTypeStructure {
+		return
+	}
+	var size C.int
+	C.object_structure_members(o.cptr, nil, &size)
+	if size == 0 {
+		return
+	}
+	ptrs := make([]*C.YR_OBJECT, int(size))
+	C.object_structure_members(o.cptr, &ptrs[0], &size)
+	for _, ptr := range ptrs {
+		members = append(members, &Object{ptr})
+	}
+	runtime.KeepAlive(o)
+	return
+}
+
+// Member returns the member of a structure object identified by
+// name. It returns nil if no such member exists or if the object is
+// not a structure.
+func (o *Object) Member(name string) *Object {
+	for _, m := range o.Members() {
+		if m.Identifier() == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Len returns the number of items in an array or dictionary object.
+// For other object types, 0 is returned.
+func (o *Object) Len() (n int) {
+	switch o.Type() {
+	case ObjectTypeArray:
+		n = int(C.object_array_length(o.cptr))
+	case ObjectTypeDictionary:
+		n = int(C.object_dictionary_length(o.cptr))
+	}
+	runtime.KeepAlive(o)
+	return
+}
+
+// Item returns the item at index i of an array object. It returns nil
+// if the item has not been set or if the object is not an array.
+func (o *Object) Item(i int) *Object {
+	if o.Type() != ObjectTypeArray {
+		return nil
+	}
+	var item *Object
+	if ptr := C.object_array_item(o.cptr, C.int(i)); ptr != nil {
+		item = &Object{ptr}
+	}
+	runtime.KeepAlive(o)
+	return item
+}
+
+// Keys returns the keys of a dictionary object in insertion order.
+// For other object types, nil is returned.
+func (o *Object) Keys() (keys []string) {
+	if o.Type() != ObjectTypeDictionary {
+		return
+	}
+	n := o.Len()
+	for i := 0; i < n; i++ {
+		var ckey *C.char
+		var length C.uint32_t
+		if C.object_dictionary_item(o.cptr, C.int(i), &ckey, &length) == nil {
+			continue
+		}
+		keys = append(keys, string(C.GoBytes(unsafe.Pointer(ckey), C.int(length))))
+	}
+	runtime.KeepAlive(o)
+	return
+}
+
+// Lookup returns the item of a dictionary object identified by key.
+// It returns nil if no such item exists or if the object is not a
+// dictionary.
+func (o *Object) Lookup(key string) *Object {
+	if o.Type() != ObjectTypeDictionary {
+		return nil
+	}
+	var item *Object
+	n := o.Len()
+	for i := 0; i < n; i++ {
+		var ckey *C.char
+		var length C.uint32_t
+		ptr := C.object_dictionary_item(o.cptr, C.int(i), &ckey, &length)
+		if ptr == nil {
+			continue
+		}
+		if string(C.GoBytes(unsafe.Pointer(ckey), C.int(length))) == key {
+			item = &Object{ptr}
+			break
+		}
+	}
+	runtime.KeepAlive(o)
+	return item
+}
+
+// Get looks up an object using a path expression that uses the same
+// syntax as YARA rule conditions, e.g. "sections[0].name" or
+// `version_info["CompanyName"]`. If the first path element is equal
+// to the object's identifier (e.g. "pe.sections[0].name" for the pe
+// module object), it is skipped.
+func (o *Object) Get(path string) (*Object, error) {
+	elems, err := parseObjectPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) > 0 && elems[0].member != "" && o.Member(elems[0].member) == nil &&
+		elems[0].member == o.Identifier() {
+		elems = elems[1:]
+	}
+	cur := o
+	for _, e := range elems {
+		var next *Object
+		switch {
+		case e.member != "":
+			if cur.Type() != ObjectTypeStructure {
+				return nil, fmt.Errorf("%s: %q: %w", path, e.member, ErrObjectType)
+			}
+			next = cur.Member(e.member)
+		case e.isKey:
+			if cur.Type() != ObjectTypeDictionary {
+				return nil, fmt.Errorf("%s: [%q]: %w", path, e.key, ErrObjectType)
+			}
+			next = cur.Lookup(e.key)
+		default:
+			if cur.Type() != ObjectTypeArray {
+				return nil, fmt.Errorf("%s: [%d]: %w", path, e.index, ErrObjectType)
+			}
+			next = cur.Item(e.index)
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: %w", path, ErrObjectNotFound)
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+type objectPathElem struct {
+	member string
+	key    string
+	index  int
+	isKey  bool
+}
+
+// parseObjectPath splits a path such as `a.b[1].c["x"]` into its
+// elements.
+func parseObjectPath(path string) (elems []objectPathElem, err error) {
+	rest := path
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			if len(elems) == 0 {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			rest = rest[1:]
+			fallthrough
+		default:
+			n := strings.IndexAny(rest, ".[")
+			if n == -1 {
+				n = len(rest)
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			elems = append(elems, objectPathElem{member: rest[:n]})
+			rest = rest[n:]
+		case '[':
+			n := strings.IndexByte(rest, ']')
+			if len(rest) > 1 && rest[1] == '"' {
+				// Find the closing quote, skipping escaped
+				// characters.
+				n = 2
+				for n < len(rest) && rest[n] != '"' {
+					if rest[n] == '\\' {
+						n++
+					}
+					n++
+				}
+				if n+1 >= len(rest) || rest[n+1] != ']' {
+					return nil, fmt.Errorf("invalid path %q", path)
+				}
+				var key string
+				if key, err = strconv.Unquote(rest[1 : n+1]); err != nil {
+					return nil, fmt.Errorf("invalid path %q", path)
+				}
+				n++
+				elems = append(elems, objectPathElem{key: key, isKey: true})
+			} else {
+				if n == -1 {
+					return nil, fmt.Errorf("invalid path %q", path)
+				}
+				var i int
+				if i, err = strconv.Atoi(rest[1:n]); err != nil || i < 0 {
+					return nil, fmt.Errorf("invalid path %q", path)
+				}
+				elems = append(elems, objectPathElem{index: i})
+			}
+			rest = rest[n+1:]
+		}
+	}
+	return
+}
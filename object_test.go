@@ -0,0 +1,113 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type objectCallback struct {
+	MatchRules
+	values map[string]interface{}
+	keys   []string
+	types  map[string]ObjectType
+	errs   []error
+}
+
+func (c *objectCallback) ModuleImported(_ *ScanContext, o *Object) (bool, error) {
+	if o.Identifier() != "tests" {
+		return false, nil
+	}
+	for _, path := range []string{
+		"tests.constants.one",
+		"constants.foo",
+		"integer_array[1]",
+		"struct_array[1].i",
+		`string_dict["foo"]`,
+		"undefined.i",
+		"undefined.f",
+	} {
+		obj, err := o.Get(path)
+		if err != nil {
+			c.errs = append(c.errs, err)
+			continue
+		}
+		c.values[path] = obj.Value()
+	}
+	if d, err := o.Get("string_dict"); err == nil {
+		c.keys = d.Keys()
+	}
+	for _, m := range o.Members() {
+		c.types[m.Identifier()] = m.Type()
+	}
+	if _, err := o.Get("constants.nonexistent"); err == nil {
+		c.errs = append(c.errs, errors.New("constants.nonexistent: no error"))
+	}
+	return false, nil
+}
+
+func TestObject(t *testing.T) {
+	r := makeRules(t, `
+		import "tests"
+		rule t { condition: tests.constants.one == 1 }`)
+	cb := &objectCallback{
+		values: make(map[string]interface{}),
+		types:  make(map[string]ObjectType),
+	}
+	if err := r.ScanMem([]byte(""), 0, 0, cb); err != nil {
+		t.Fatal(err)
+	}
+	if len(cb.errs) > 0 {
+		t.Errorf("errors: %v", cb.errs)
+	}
+	expected := map[string]interface{}{
+		"tests.constants.one": int64(1),
+		"constants.foo":       []byte("foo"),
+		"integer_array[1]":    int64(1),
+		"struct_array[1].i":   int64(1),
+		`string_dict["foo"]`:  []byte("foo"),
+		"undefined.i":         Undefined,
+		"undefined.f":         Undefined,
+	}
+	if !reflect.DeepEqual(cb.values, expected) {
+		t.Errorf("got %+v, expected %+v", cb.values, expected)
+	}
+	if !reflect.DeepEqual(cb.keys, []string{"foo", "bar"}) {
+		t.Errorf("string_dict keys: %v", cb.keys)
+	}
+	for id, typ := range map[string]ObjectType{
+		"constants":    ObjectTypeStructure,
+		"module_data":  ObjectTypeString,
+		"struct_array": ObjectTypeArray,
+		"string_dict":  ObjectTypeDictionary,
+		"match":        ObjectTypeFunction,
+	} {
+		if cb.types[id] != typ {
+			t.Errorf("%s: expected type %s, got %s", id, typ, cb.types[id])
+		}
+	}
+}
+
+func TestParseObjectPath(t *testing.T) {
+	for path, expected := range map[string][]objectPathElem{
+		"a.b[1].c":     {{member: "a"}, {member: "b"}, {index: 1}, {member: "c"}},
+		`d["x]\"y"].e`: {{member: "d"}, {key: `x]"y`, isKey: true}, {member: "e"}},
+	} {
+		if got, err := parseObjectPath(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: got %+v, expected %+v", path, got, expected)
+		}
+	}
+	for _, path := range []string{".a", "a..b", "a[", "a[-1]", `a["x"`, "a[x]"} {
+		if _, err := parseObjectPath(path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Profiler aggregates profiling information from many Scanners,
+// e.g. over a scan campaign that uses a pool of workers. It is safe
+// for concurrent use. The zero value is ready to use; the collection
+// period then starts with the first call to Collect.
+//
+// Profiling information is only available if libyara has been built
+// with profiling support. Costs are reported by libyara as
+// nanoseconds.
+type Profiler struct {
+	// MaxTargets is the number of slowest targets that are kept
+	// per rule. If it is 0, 10 targets are kept.
+	MaxTargets int
+
+	mu    sync.Mutex
+	start time.Time
+	scans int
+	rules map[[2]string]*RuleProfile
+}
+
+// RuleProfile contains the aggregated profiling information for a
+// rule.
+type RuleProfile struct {
+	Namespace string
+	Rule      string
+	// Cost is the total cost of the rule.
+	Cost uint64
+	// Scans is the number of scans in which the rule had a non-zero
+	// cost.
+	Scans int
+	// SlowestTargets contains the targets with the highest costs
+	// for the rule, most expensive first.
+	SlowestTargets []TargetCost
+}
+
+// TargetCost is the cost of a rule for a single target.
+type TargetCost struct {
+	Target string
+	Cost   uint64
+}
+
+// NamespaceProfile contains the aggregated profiling information for
+// a namespace.
+type NamespaceProfile struct {
+	Namespace string
+	Cost      uint64
+	// Rules is the number of rules in the namespace with non-zero
+	// cost.
+	Rules int
+}
+
+// ruleCost is the cost of a rule in a single scan.
+type ruleCost struct {
+	namespace, rule string
+	cost            uint64
+}
+
+// NewProfiler creates a Profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{start: time.Now(), rules: make(map[[2]string]*RuleProfile)}
+}
+
+// Collect adds the Scanner's profiling information for target, which
+// is used to identify the slowest targets, and resets the Scanner's
+// profiling information. Collect should be called after every scan.
+func (p *Profiler) Collect(s *Scanner, target string) {
+	var costs []ruleCost
+	for _, rpi := range s.GetProfilingInfo() {
+		if rpi.Cost == 0 {
+			continue
+		}
+		costs = append(costs, ruleCost{rpi.Namespace(), rpi.Identifier(), rpi.Cost})
+	}
+	s.ResetProfilingInfo()
+	p.add(target, costs)
+}
+
+func (p *Profiler) add(target string, costs []ruleCost) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rules == nil {
+		p.rules = make(map[[2]string]*RuleProfile)
+	}
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	maxTargets := p.MaxTargets
+	if maxTargets <= 0 {
+		maxTargets = 10
+	}
+	p.scans++
+	for _, c := range costs {
+		key := [2]string{c.namespace, c.rule}
+		rp := p.rules[key]
+		if rp == nil {
+			rp = &RuleProfile{Namespace: c.namespace, Rule: c.rule}
+			p.rules[key] = rp
+		}
+		rp.Cost += c.cost
+		rp.Scans++
+		// Insert into SlowestTargets, keeping it sorted.
+		i := sort.Search(len(rp.SlowestTargets), func(i int) bool { return rp.SlowestTargets[i].Cost < c.cost })
+		if i >= maxTargets {
+			continue
+		}
+		if len(rp.SlowestTargets) < maxTargets {
+			rp.SlowestTargets = append(rp.SlowestTargets, TargetCost{})
+		}
+		copy(rp.SlowestTargets[i+1:], rp.SlowestTargets[i:])
+		rp.SlowestTargets[i] = TargetCost{target, c.cost}
+	}
+}
+
+// Reset discards all collected information.
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	p.start, p.scans, p.rules = time.Now(), 0, make(map[[2]string]*RuleProfile)
+	p.mu.Unlock()
+}
+
+// Scans returns the number of scans that have been collected.
+func (p *Profiler) Scans() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scans
+}
+
+// Rules returns the aggregated information per rule, most expensive
+// first.
+func (p *Profiler) Rules() []RuleProfile {
+	_, rps, _ := p.snapshot()
+	return rps
+}
+
+// snapshot returns the number of scans, the rule profiles as returned
+// by Rules, and the start of the collection period.
+func (p *Profiler) snapshot() (scans int, rps []RuleProfile, start time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rps = make([]RuleProfile, 0, len(p.rules))
+	for _, rp := range p.rules {
+		r := *rp
+		r.SlowestTargets = append([]TargetCost(nil), rp.SlowestTargets...)
+		rps = append(rps, r)
+	}
+	sort.Slice(rps, func(i, j int) bool {
+		if rps[i].Cost != rps[j].Cost {
+			return rps[i].Cost > rps[j].Cost
+		}
+		if rps[i].Namespace != rps[j].Namespace {
+			return rps[i].Namespace < rps[j].Namespace
+		}
+		return rps[i].Rule < rps[j].Rule
+	})
+	return p.scans, rps, p.start
+}
+
+// Namespaces returns the aggregated information per namespace, most
+// expensive first.
+func (p *Profiler) Namespaces() []NamespaceProfile {
+	return namespaceProfiles(p.Rules())
+}
+
+// namespaceProfiles aggregates rule profiles per namespace.
+func namespaceProfiles(rps []RuleProfile) []NamespaceProfile {
+	byNs := make(map[string]*NamespaceProfile)
+	var nps []NamespaceProfile
+	for _, rp := range rps {
+		np := byNs[rp.Namespace]
+		if np == nil {
+			np = &NamespaceProfile{Namespace: rp.Namespace}
+			byNs[rp.Namespace] = np
+		}
+		np.Cost += rp.Cost
+		np.Rules++
+	}
+	for _, np := range byNs {
+		nps = append(nps, *np)
+	}
+	sort.Slice(nps, func(i, j int) bool {
+		if nps[i].Cost != nps[j].Cost {
+			return nps[i].Cost > nps[j].Cost
+		}
+		return nps[i].Namespace < nps[j].Namespace
+	})
+	return nps
+}
+
+// WriteTop writes a text report of the n most expensive rules,
+// including their slowest targets, followed by the costs per
+// namespace. If n is 0, all rules are included.
+func (p *Profiler) WriteTop(w io.Writer, n int) error {
+	scans, rps, _ := p.snapshot()
+	nps := namespaceProfiles(rps)
+	var total uint64
+	for _, rp := range rps {
+		total += rp.Cost
+	}
+	percent := func(cost uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(cost) / float64(total)
+	}
+	if n > 0 && n < len(rps) {
+		rps = rps[:n]
+	}
+	if _, err := fmt.Fprintf(w, "%d scans, total cost %v\n\n%12s %7s %8s  %s\n",
+		scans, time.Duration(total), "cost", "cost%", "scans", "rule"); err != nil {
+		return err
+	}
+	for _, rp := range rps {
+		if _, err := fmt.Fprintf(w, "%12v %6.2f%% %8d  %s.%s\n",
+			time.Duration(rp.Cost), percent(rp.Cost), rp.Scans, rp.Namespace, rp.Rule); err != nil {
+			return err
+		}
+		for _, tc := range rp.SlowestTargets {
+			if _, err := fmt.Fprintf(w, "%12v %7s %8s    %s\n", time.Duration(tc.Cost), "", "", tc.Target); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := fmt.Fprintf(w, "\n%12s %7s %8s  %s\n", "cost", "cost%", "rules", "namespace"); err != nil {
+		return err
+	}
+	for _, np := range nps {
+		if _, err := fmt.Fprintf(w, "%12v %6.2f%% %8d  %s\n",
+			time.Duration(np.Cost), percent(np.Cost), np.Rules, np.Namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePprof writes the aggregated costs as a gzip-compressed
+// profile in the protocol buffer format that is understood by "go
+// tool pprof". Each rule is represented as a call stack consisting
+// of a namespace frame and a rule frame. The sample values are the
+// number of scans in which the rule had a cost and the cost in
+// nanoseconds.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	_, rps, start := p.snapshot()
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	var b protoBuffer
+	stringIndex := map[string]int{"": 0}
+	stringTable := []string{""}
+	str := func(s string) uint64 {
+		i, ok := stringIndex[s]
+		if !ok {
+			i = len(stringTable)
+			stringIndex[s] = i
+			stringTable = append(stringTable, s)
+		}
+		return uint64(i)
+	}
+	valueType := func(typ, unit string) []byte {
+		var vt protoBuffer
+		vt.uint64(1, str(typ))
+		vt.uint64(2, str(unit))
+		return vt.data
+	}
+	b.bytes(1, valueType("scans", "count"))
+	b.bytes(1, valueType("cost", "nanoseconds"))
+
+	// Functions and locations share IDs; a function is created for
+	// each namespace and each rule. They are kept apart since the
+	// namespace "x.y" and the rule "y" in namespace "x" have the
+	// same name.
+	type frameKey struct {
+		namespace bool
+		name      string
+	}
+	ids := make(map[frameKey]uint64)
+	var functions, locations [][]byte
+	frame := func(namespace bool, name string) uint64 {
+		key := frameKey{namespace, name}
+		if id, ok := ids[key]; ok {
+			return id
+		}
+		id := uint64(len(ids) + 1)
+		ids[key] = id
+		var fn, line, loc protoBuffer
+		fn.uint64(1, id)
+		fn.uint64(2, str(name))
+		fn.uint64(3, str(name))
+		functions = append(functions, fn.data)
+		line.uint64(1, id)
+		loc.uint64(1, id)
+		loc.bytes(4, line.data)
+		locations = append(locations, loc.data)
+		return id
+	}
+	for _, rp := range rps {
+		// Rule names are qualified, since pprof merges functions
+		// with the same name.
+		leaf := frame(false, rp.Namespace+"."+rp.Rule)
+		root := frame(true, rp.Namespace)
+		var sample, locIDs, values protoBuffer
+		locIDs.varint(leaf)
+		locIDs.varint(root)
+		values.varint(uint64(rp.Scans))
+		values.varint(rp.Cost)
+		sample.bytes(1, locIDs.data)
+		sample.bytes(2, values.data)
+		b.bytes(2, sample.data)
+	}
+	for _, loc := range locations {
+		b.bytes(4, loc)
+	}
+	for _, fn := range functions {
+		b.bytes(5, fn)
+	}
+	b.uint64(9, uint64(start.UnixNano()))
+	b.uint64(10, uint64(time.Since(start)))
+	b.bytes(11, valueType("cost", "nanoseconds"))
+	b.uint64(14, str("cost"))
+	for _, s := range stringTable {
+		b.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer implements the parts of the protocol buffer encoding
+// that are needed for pprof profiles.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// uint64 encodes a varint field.
+func (b *protoBuffer) uint64(field int, x uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+// bytes encodes a length-delimited field.
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
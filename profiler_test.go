@@ -0,0 +1,75 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	p := NewProfiler()
+	p.MaxTargets = 2
+	p.add("a.exe", []ruleCost{{"default", "slow", 300}, {"extra", "fast", 10}})
+	p.add("b.exe", []ruleCost{{"default", "slow", 500}})
+	p.add("c.exe", []ruleCost{{"default", "slow", 100}, {"extra", "fast", 20}})
+
+	rps := p.Rules()
+	if len(rps) != 2 || rps[0].Rule != "slow" || rps[0].Cost != 900 || rps[0].Scans != 3 {
+		t.Fatalf("unexpected rule profiles: %+v", rps)
+	}
+	if expected := []TargetCost{{"b.exe", 500}, {"a.exe", 300}}; !reflect.DeepEqual(rps[0].SlowestTargets, expected) {
+		t.Errorf("slowest targets: got %+v, expected %+v", rps[0].SlowestTargets, expected)
+	}
+	if nps := p.Namespaces(); !reflect.DeepEqual(nps, []NamespaceProfile{{"default", 900, 1}, {"extra", 30, 1}}) {
+		t.Errorf("namespaces: %+v", nps)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteTop(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	report := buf.String()
+	if !strings.Contains(report, "default.slow") || strings.Contains(report, "extra.fast") ||
+		!strings.Contains(report, "b.exe") || !strings.Contains(report, "3 scans") {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+
+	buf.Reset()
+	if err := p.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"default.slow", "extra.fast", "nanoseconds"} {
+		if !bytes.Contains(profile, []byte(s)) {
+			t.Errorf("profile does not contain %q", s)
+		}
+	}
+}
+
+func TestProfilerZeroValue(t *testing.T) {
+	var p Profiler
+	p.add("a.exe", []ruleCost{{"x", "y", 10}, {"x.y", "z", 20}})
+	if p.Scans() != 1 || len(p.Rules()) != 2 {
+		t.Errorf("unexpected profiles: %+v", p.Rules())
+	}
+	var buf bytes.Buffer
+	if err := p.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+/*
+#include <yara.h>
+#include <yara/re.h>
+
+// re_node_* are union accessor functions.
+// (CGO does not represent them properly to Go code.)
+static int re_node_value(RE_NODE* n) { return n->value; }
+static int re_node_start(RE_NODE* n) { return n->start; }
+static int re_node_mask(RE_NODE* n) { return n->mask; }
+static int re_node_end(RE_NODE* n) { return n->end; }
+
+// re_node_class_size returns the number of byte values that are
+// matched by a character class.
+static int re_node_class_size(RE_NODE* n) {
+	int i, size = 0;
+	if (n->re_class == NULL)
+		return 0;
+	for (i = 0; i < 256; i++)
+		if (n->re_class->bitmap[i / 8] & (1 << (i % 8)))
+			size++;
+	return n->re_class->negated ? 256 - size : size;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+const (
+	reNodeLiteral          = C.RE_NODE_LITERAL
+	reNodeMaskedLiteral    = C.RE_NODE_MASKED_LITERAL
+	reNodeAny              = C.RE_NODE_ANY
+	reNodeConcat           = C.RE_NODE_CONCAT
+	reNodeAlt              = C.RE_NODE_ALT
+	reNodeRange            = C.RE_NODE_RANGE
+	reNodeStar             = C.RE_NODE_STAR
+	reNodePlus             = C.RE_NODE_PLUS
+	reNodeClass            = C.RE_NODE_CLASS
+	reNodeNonWordChar      = C.RE_NODE_NON_WORD_CHAR
+	reNodeNonSpace         = C.RE_NODE_NON_SPACE
+	reNodeNonDigit         = C.RE_NODE_NON_DIGIT
+	reNodeRangeAny         = C.RE_NODE_RANGE_ANY
+	reNodeNotLiteral       = C.RE_NODE_NOT_LITERAL
+	reNodeMaskedNotLiteral = C.RE_NODE_MASKED_NOT_LITERAL
+	// reMaxRange is used by libyara as upper bound for {n,} and
+	// [n-].
+	reMaxRange = math.MaxInt16
+)
+
+// reNode is a copy of the relevant parts of a RE_NODE.
+type reNode struct {
+	typ       int
+	value     int // literal value, or start of range
+	end       int // mask of masked literal, or end of range
+	classSize int
+	children  []*reNode
+}
+
+func newReNode(n *C.RE_NODE) *reNode {
+	node := &reNode{typ: int(n._type)}
+	switch node.typ {
+	case reNodeLiteral, reNodeNotLiteral:
+		node.value = int(C.re_node_value(n))
+	case reNodeMaskedLiteral, reNodeMaskedNotLiteral:
+		node.value, node.end = int(C.re_node_value(n)), int(C.re_node_mask(n))
+	case reNodeRange, reNodeRangeAny:
+		node.value, node.end = int(C.re_node_start(n)), int(C.re_node_end(n))
+	case reNodeClass:
+		node.classSize = int(C.re_node_class_size(n))
+	}
+	for child := n.children_head; child != nil; child = child.next_sibling {
+		node.children = append(node.children, newReNode(child))
+	}
+	return node
+}
+
+// isQuantifier returns true for nodes that repeat their child.
+func (n *reNode) isQuantifier() bool {
+	switch n.typ {
+	case reNodeStar, reNodePlus:
+		return true
+	case reNodeRange:
+		return n.end > 1
+	}
+	return false
+}
+
+// isWide returns true for nodes that match more than half of all
+// byte values.
+func (n *reNode) isWide() bool {
+	switch n.typ {
+	case reNodeAny, reNodeNotLiteral, reNodeMaskedNotLiteral,
+		reNodeNonWordChar, reNodeNonSpace, reNodeNonDigit:
+		return true
+	case reNodeClass:
+		return n.classSize > 128
+	}
+	return false
+}
+
+// isUnboundedJump returns true for constructs like .*, [^x]+, .{n,}
+// and [n-].
+func (n *reNode) isUnboundedJump() bool {
+	switch n.typ {
+	case reNodeStar, reNodePlus:
+		return n.children[0].isWide()
+	case reNodeRange:
+		return n.end == reMaxRange && n.children[0].isWide()
+	case reNodeRangeAny:
+		return n.end == reMaxRange
+	}
+	return false
+}
+
+// count returns the number of nodes in the tree for which f is true.
+func (n *reNode) count(f func(*reNode) bool) (c int) {
+	if f(n) {
+		c++
+	}
+	for _, child := range n.children {
+		c += child.count(f)
+	}
+	return
+}
+
+// nestedQuantifiers returns the number of quantifiers that contain
+// another quantifier.
+func (n *reNode) nestedQuantifiers() int {
+	return n.count(func(n *reNode) bool {
+		if !n.isQuantifier() {
+			return false
+		}
+		for _, child := range n.children {
+			if child.count((*reNode).isQuantifier) > 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// atomByte returns the byte and mask that n contributes to an atom,
+// following libyara's atom extraction: literals, masked literals and
+// wildcards (mask 0) can be part of an atom.
+func (n *reNode) atomByte() (b, mask byte, ok bool) {
+	switch n.typ {
+	case reNodeLiteral:
+		return byte(n.value), 0xff, true
+	case reNodeMaskedLiteral:
+		return byte(n.value & n.end), byte(n.end), true
+	case reNodeAny:
+		return 0, 0, true
+	}
+	return 0, 0, false
+}
+
+// atomQuality returns the quality of the best atom that can be
+// extracted from the tree, as rated by quality. This is a simplified
+// version of libyara's atom extraction: Every run of up to
+// maxAtomLength atom bytes in a concatenation is a candidate, and
+// alternatives are rated by their worst branch. Subtrees that may
+// match the empty string contribute no atom.
+func (n *reNode) atomQuality(quality atomQualityFunc) int {
+	if _, _, ok := n.atomByte(); ok {
+		return n.runQuality([]*reNode{n}, quality)
+	}
+	switch n.typ {
+	case reNodeConcat:
+		best := quality(nil, nil)
+		for _, child := range n.children {
+			if q := child.atomQuality(quality); q > best {
+				best = q
+			}
+		}
+		if q := n.runQuality(n.children, quality); q > best {
+			best = q
+		}
+		return best
+	case reNodeAlt:
+		worst := math.MaxInt32
+		for _, child := range n.children {
+			if q := child.atomQuality(quality); q < worst {
+				worst = q
+			}
+		}
+		return worst
+	case reNodePlus:
+		return n.children[0].atomQuality(quality)
+	case reNodeRange:
+		if n.value > 0 {
+			return n.children[0].atomQuality(quality)
+		}
+	}
+	return quality(nil, nil)
+}
+
+// runQuality returns the quality of the best atom that consists of
+// consecutive atom bytes from nodes.
+func (n *reNode) runQuality(nodes []*reNode, quality atomQualityFunc) int {
+	best := quality(nil, nil)
+	for i := range nodes {
+		var atom, mask []byte
+		for j := i; j < len(nodes) && j < i+maxAtomLength; j++ {
+			b, m, ok := nodes[j].atomByte()
+			if !ok {
+				break
+			}
+			atom, mask = append(atom, b), append(mask, m)
+			if q := quality(atom, mask); q > best {
+				best = q
+			}
+		}
+	}
+	return best
+}
+
+// A RegexReport describes the complexity of a regular expression or
+// hex string, as determined by EnableRegexAnalysis.
+type RegexReport struct {
+	Filename string
+	Line     int
+	Rule     string
+	String   string
+	// AtomQuality is the quality of the best atom that can be
+	// extracted, on libyara's scale of 0 to 255. Atoms are rated
+	// like libyara does, using the atom quality table if one has
+	// been set or libyara's heuristic. Since the atoms themselves
+	// are chosen by a simplified version of libyara's algorithm,
+	// this is an approximation of the quality that libyara uses.
+	AtomQuality int
+	// UnboundedJumps is the number of constructs such as .*,
+	// [^x]+, .{n,}, or [n-].
+	UnboundedJumps int
+	// NestedQuantifiers is the number of repetitions that contain
+	// another repetition, such as (ab+)*.
+	NestedQuantifiers int
+}
+
+// regexAnalysis records the settings passed to EnableRegexAnalysis and
+// the results.
+type regexAnalysis struct {
+	minAtomQuality int
+	reports        []RegexReport
+}
+
+// EnableRegexAnalysis makes the compiler analyze every regular
+// expression and hex string. The results are available through
+// RegexReports. Warnings are produced for unbounded jumps
+// (WarningSlowRegex), nested quantifiers (WarningNestedQuantifier),
+// and strings whose approximated atom quality (see RegexReport) is
+// below minAtomQuality (WarningLowAtomQuality). Without an atom
+// quality table, libyara itself produces slow-string warnings below
+// AtomQualityWarningThreshold.
+func (c *Compiler) EnableRegexAnalysis(minAtomQuality int) {
+	c.regexAnalysis = &regexAnalysis{minAtomQuality: minAtomQuality}
+}
+
+// RegexReports returns the results of the analysis that has been
+// enabled by EnableRegexAnalysis.
+func (c *Compiler) RegexReports() []RegexReport {
+	if c.regexAnalysis == nil {
+		return nil
+	}
+	return append([]RegexReport(nil), c.regexAnalysis.reports...)
+}
+
+//export reAstCallback
+func reAstCallback(rule *C.YR_RULE, identifier *C.char, reAst *C.RE_AST, userData unsafe.Pointer) {
+	c := cgoHandle(*(*uintptr)(userData)).Value().(*Compiler)
+	defer func() {
+		if p := recover(); p != nil {
+			c.callbackErr = newCallbackPanicError(p)
+		}
+	}()
+	if reAst == nil || reAst.root_node == nil {
+		return
+	}
+	rep := RegexReport{
+		Filename: C.GoString(C.yr_compiler_get_current_file_name(c.cptr)),
+		Line:     int(c.cptr.current_line),
+		String:   C.GoString(identifier),
+	}
+	if rep.Filename == "" {
+		rep.Filename = c.filename
+	}
+	if rule != nil {
+		rep.Rule = qualifiedRuleName(rule)
+	}
+	c.regexAnalysis.analyze(&rep, newReNode(reAst.root_node), c.atomQuality())
+	for _, msg := range c.regexAnalysis.messages(rep) {
+		c.messages = append(c.messages, msg)
+	}
+}
+
+func (ra *regexAnalysis) analyze(rep *RegexReport, root *reNode, quality atomQualityFunc) {
+	rep.AtomQuality = root.atomQuality(quality)
+	rep.UnboundedJumps = root.count((*reNode).isUnboundedJump)
+	rep.NestedQuantifiers = root.nestedQuantifiers()
+	ra.reports = append(ra.reports, *rep)
+}
+
+// messages returns warnings for the problems found in rep.
+func (ra *regexAnalysis) messages(rep RegexReport) (msgs []CompilerMessage) {
+	add := func(category WarningCategory, format string, args ...interface{}) {
+		text := fmt.Sprintf("string \"%s\": ", rep.String) + fmt.Sprintf(format, args...)
+		if rep.Rule != "" {
+			text = fmt.Sprintf("rule \"%s\": ", rep.Rule) + text
+		}
+		msgs = append(msgs, CompilerMessage{
+			Filename: rep.Filename,
+			Line:     rep.Line,
+			Text:     text,
+			Rule:     rep.Rule,
+			String:   rep.String,
+			Severity: SeverityWarning,
+			Category: category,
+		})
+	}
+	if rep.UnboundedJumps > 0 {
+		add(WarningSlowRegex, "%d unbounded jump(s)", rep.UnboundedJumps)
+	}
+	if rep.NestedQuantifiers > 0 {
+		add(WarningNestedQuantifier, "%d nested quantifier(s)", rep.NestedQuantifiers)
+	}
+	if rep.AtomQuality < ra.minAtomQuality {
+		add(WarningLowAtomQuality, "approximate atom quality %d is below %d", rep.AtomQuality, ra.minAtomQuality)
+	}
+	return
+}
@@ -0,0 +1,88 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import "testing"
+
+func lit(b byte) *reNode { return &reNode{typ: reNodeLiteral, value: int(b)} }
+
+func TestReNodeAnalysis(t *testing.T) {
+	for _, tc := range []struct {
+		name                   string
+		root                   *reNode
+		quality, jumps, nested int
+	}{
+		// abcd.*
+		{"abcd.*", &reNode{typ: reNodeConcat, children: []*reNode{
+			lit('a'), lit('b'), lit('c'), lit('d'),
+			{typ: reNodeStar, children: []*reNode{{typ: reNodeAny}}},
+		}}, 247, 1, 0},
+		// (ab+)*\x00
+		{`(ab+)*\x00`, &reNode{typ: reNodeConcat, children: []*reNode{
+			{typ: reNodeStar, children: []*reNode{{typ: reNodeConcat, children: []*reNode{
+				lit('a'), {typ: reNodePlus, children: []*reNode{lit('b')}},
+			}}}},
+			lit(0),
+		}}, 169, 0, 1},
+		// (abc|x)[^y]{2,}
+		{"(abc|x)[^y]{2,}", &reNode{typ: reNodeConcat, children: []*reNode{
+			{typ: reNodeAlt, children: []*reNode{
+				{typ: reNodeConcat, children: []*reNode{lit('a'), lit('b'), lit('c')}},
+				lit('x'),
+			}},
+			{typ: reNodeRange, value: 2, end: reMaxRange, children: []*reNode{{typ: reNodeClass, classSize: 255}}},
+		}}, 187, 1, 0},
+		// { 00 [4-] ?1 }
+		{"{ 00 [4-] ?1 }", &reNode{typ: reNodeConcat, children: []*reNode{
+			lit(0),
+			{typ: reNodeRangeAny, value: 4, end: reMaxRange},
+			{typ: reNodeMaskedLiteral, value: 1, end: 0x0f},
+		}}, 171, 1, 0},
+	} {
+		if q := tc.root.atomQuality(heuristicAtomQuality); q != tc.quality {
+			t.Errorf("%s: expected atom quality %d, got %d", tc.name, tc.quality, q)
+		}
+		if j := tc.root.count((*reNode).isUnboundedJump); j != tc.jumps {
+			t.Errorf("%s: expected %d unbounded jumps, got %d", tc.name, tc.jumps, j)
+		}
+		if n := tc.root.nestedQuantifiers(); n != tc.nested {
+			t.Errorf("%s: expected %d nested quantifiers, got %d", tc.name, tc.nested, n)
+		}
+	}
+}
+
+func TestRegexAnalysis(t *testing.T) {
+	c, _ := NewCompiler()
+	c.EnableRegexAnalysis(30)
+	c.SetWarningAction(WarningNestedQuantifier, WarningError)
+	if err := c.AddString(`
+rule a {
+  strings:
+    $a = /abcd.*efgh/
+    $b = /(ab+)*cdef/
+  condition:
+    any of them
+}`, "ns"); err != nil {
+		t.Fatal(err)
+	}
+	reports := c.RegexReports()
+	if len(reports) != 2 || reports[0].Rule != "ns.a" || reports[0].String != "$a" || reports[0].UnboundedJumps != 1 {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+	var found bool
+	for _, w := range c.Warnings {
+		if w.Category == WarningSlowRegex && w.String == "$a" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no slow-regex warning for $a: %+v", c.Warnings)
+	}
+	if _, err := c.GetRules(); err == nil {
+		t.Error("expected error for nested quantifier in $b")
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+/*
+#include <yara.h>
+
+// compiler_has_rule returns 1 if a rule with the identifier has been
+// added to the namespace.
+static int compiler_has_rule(YR_COMPILER* c, const char* identifier, const char* ns) {
+	return yr_hash_table_lookup_uint32(c->rules_table, identifier, ns) != UINT32_MAX;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// A Source describes a set of rules that is to be compiled.
+type Source struct {
+	// Filename is used in CompilerMessage values and for resolving
+	// relative includes: Unless an IncludeResolver has been set,
+	// SearchPath(nil) is used, so that relative includes are looked
+	// up in the directory of Filename. If Rules is nil, the rules
+	// are read from this file.
+	Filename  string
+	Namespace string
+	Rules     []byte
+}
+
+func (s Source) read() ([]byte, error) {
+	if s.Rules != nil {
+		return s.Rules, nil
+	}
+	return ioutil.ReadFile(s.Filename)
+}
+
+// A FileReport describes the outcome of compiling a Source.
+type FileReport struct {
+	Filename  string
+	Namespace string
+	// Err is set if the source has been skipped. It is a
+	// *CompileError if the rules could not be compiled.
+	Err      error
+	Errors   []CompilerMessage
+	Warnings []CompilerMessage
+	// Duration is the time it took to compile the source. It is
+	// only set by Validate.
+	Duration time.Duration
+}
+
+// TryAddString is like AddString, but the Compiler stays usable if
+// the rules cannot be compiled. See TryAddBytes.
+func (c *Compiler) TryAddString(rules string, namespace string) error {
+	return c.tryAdd(Source{Namespace: namespace, Rules: []byte(rules)}).Err
+}
+
+// TryAddBytes is like AddBytes, but the Compiler stays usable if the
+// rules cannot be compiled: The rules are first compiled in isolation
+// using a separate Compiler with the same variables, include
+// resolver and warning actions. They are only added if that succeeds,
+// including warnings that are promoted to errors, and if none of the
+// rule identifiers clashes with rules that have been added before.
+//
+// Since the rules are checked in isolation, they cannot refer to
+// rules that have been added before.
+func (c *Compiler) TryAddBytes(rules []byte, filename, namespace string) error {
+	return c.tryAdd(Source{Filename: filename, Namespace: namespace, Rules: rules}).Err
+}
+
+func (c *Compiler) tryAdd(src Source) (rep FileReport) {
+	rep.Filename, rep.Namespace = src.Filename, src.Namespace
+	if rep.Err = c.checkUsage(); rep.Err != nil {
+		return
+	}
+	buf, err := src.read()
+	if err != nil {
+		rep.Err = err
+		return
+	}
+	if c.include == nil {
+		// libyara's default include handling does not know
+		// src.Filename since rules are added using AddBytes.
+		restore := c.swapIncludeResolver(SearchPath(nil))
+		defer restore()
+	}
+	rules, err := c.check(buf, src, &rep)
+	if err != nil {
+		rep.Err = err
+		return
+	}
+	for _, rule := range rules {
+		if c.hasRule(rule) {
+			msg := CompilerMessage{
+				Filename: src.Filename,
+				Text:     fmt.Sprintf("duplicated identifier \"%s\"", rule[1]),
+				Rule:     rule[1],
+			}
+			rep.Errors = append(rep.Errors, msg)
+			rep.Err = &CompileError{
+				Code:     ERROR_DUPLICATED_IDENTIFIER,
+				Messages: []CompilerMessage{msg},
+				text:     msg.Text,
+			}
+			return
+		}
+	}
+	nWarnings := len(c.Warnings)
+	if rep.Err = c.AddBytes(buf, src.Filename, src.Namespace); rep.Err != nil {
+		// This should not happen, the Compiler is unusable now.
+		rep.Errors = append(rep.Errors, c.Errors...)
+		return
+	}
+	rep.Warnings = append([]CompilerMessage(nil), c.Warnings[nWarnings:]...)
+	return
+}
+
+// hasRule returns true if a rule, given as namespace and identifier,
+// has already been added to the compiler.
+func (c *Compiler) hasRule(rule [2]string) bool {
+	cns, cid := C.CString(rule[0]), C.CString(rule[1])
+	defer C.free(unsafe.Pointer(cns))
+	defer C.free(unsafe.Pointer(cid))
+	found := C.compiler_has_rule(c.cptr, cid, cns) != 0
+	runtime.KeepAlive(c)
+	return found
+}
+
+// check compiles buf using a throwaway Compiler with the same
+// variables, include resolver and warning actions, and returns the
+// namespaces and identifiers of all rules.
+func (c *Compiler) check(buf []byte, src Source, rep *FileReport) (rules [][2]string, err error) {
+	tc, err := NewCompiler()
+	if err != nil {
+		return nil, err
+	}
+	defer tc.Destroy()
+	if c.include != nil {
+		tc.SetIncludeResolver(c.include.IncludeResolver)
+	} else if c.includesDisabled {
+		tc.DisableIncludes()
+	}
+	for id, value := range c.variables {
+		if err = tc.DefineVariable(id, value); err != nil {
+			return
+		}
+	}
+	for category, action := range c.warningActions {
+		tc.SetWarningAction(category, action)
+	}
+	tc.SetWarningMetaKey(c.warningMetaKey)
+	err = tc.AddBytes(buf, src.Filename, src.Namespace)
+	rep.Errors, rep.Warnings = tc.Errors, tc.Warnings
+	if err != nil {
+		return
+	}
+	r, err := tc.GetRules()
+	if err != nil {
+		// Warnings may have been promoted to errors.
+		if ce, ok := err.(*CompileError); ok {
+			rep.Errors = append(rep.Errors, ce.Errors()...)
+		}
+		rep.Warnings = tc.Warnings
+		return
+	}
+	defer r.Destroy()
+	for _, rule := range r.GetRules() {
+		rules = append(rules, [2]string{rule.Namespace(), rule.Identifier()})
+	}
+	return
+}
+
+// LoadRepository compiles rules from sources into a single rule set,
+// defining variables first. Sources that cannot be read or compiled
+// are skipped, see TryAddBytes. A FileReport is returned for every
+// source.
+func LoadRepository(sources []Source, variables map[string]interface{}) (*Rules, []FileReport, error) {
+	c, err := NewCompiler()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.Destroy()
+	for id, value := range variables {
+		if err := c.DefineVariable(id, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	reports := make([]FileReport, len(sources))
+	for i, src := range sources {
+		reports[i] = c.tryAdd(src)
+	}
+	r, err := c.GetRules()
+	return r, reports, err
+}
+
+// A Validator compiles sources independently of each other, using a
+// separate Compiler for every source.
+type Validator struct {
+	// Variables are defined in every Compiler.
+	Variables map[string]interface{}
+	// Include is used as IncludeResolver if it is not nil,
+	// otherwise SearchPath(nil) is used, see Source. Since sources
+	// are compiled concurrently, it must be safe for concurrent use.
+	Include IncludeResolver
+	// Workers is the number of sources that are compiled
+	// concurrently. If it is 0, runtime.GOMAXPROCS(0) is used.
+	Workers int
+}
+
+// Validate compiles every source on its own and returns a FileReport
+// for each of them, in the same order. Unlike with a single
+// Compiler, an error in one source does not affect the others.
+func (v *Validator) Validate(sources ...Source) []FileReport {
+	workers := v.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	reports := make([]FileReport, len(sources))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				reports[i] = v.validate(sources[i])
+			}
+		}()
+	}
+	for i := range sources {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return reports
+}
+
+func (v *Validator) validate(src Source) (rep FileReport) {
+	rep.Filename, rep.Namespace = src.Filename, src.Namespace
+	buf, err := src.read()
+	if err != nil {
+		rep.Err = err
+		return
+	}
+	c, err := NewCompiler()
+	if err != nil {
+		rep.Err = err
+		return
+	}
+	defer c.Destroy()
+	if v.Include != nil {
+		c.SetIncludeResolver(v.Include)
+	} else {
+		c.SetIncludeResolver(SearchPath(nil))
+	}
+	for id, value := range v.Variables {
+		if rep.Err = c.DefineVariable(id, value); rep.Err != nil {
+			return
+		}
+	}
+	start := time.Now()
+	rep.Err = c.AddBytes(buf, src.Filename, src.Namespace)
+	rep.Duration = time.Since(start)
+	rep.Errors, rep.Warnings = c.Errors, c.Warnings
+	return
+}
+
+// Validate compiles every source on its own, using a worker pool.
+// See Validator.
+func Validate(sources ...Source) []FileReport {
+	var v Validator
+	return v.Validate(sources...)
+}
@@ -0,0 +1,143 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryAddString(t *testing.T) {
+	c, _ := NewCompiler()
+	if err := c.DefineVariable("x", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TryAddString("rule a { condition: x == 1 }", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TryAddString("rule b { condition: }", ""); !errors.Is(err, ErrSyntaxError) {
+		t.Errorf("expected syntax error, got %v", err)
+	}
+	if err := c.TryAddString("rule a { condition: false }", ""); !errors.Is(err, ErrDuplicatedIdentifier) {
+		t.Errorf("expected duplicated identifier, got %v", err)
+	}
+	if err := c.TryAddString("rule a { condition: false }", "other"); err != nil {
+		t.Error(err)
+	}
+	r, err := c.GetRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.GetRules()); n != 2 {
+		t.Errorf("expected 2 rules, got %d", n)
+	}
+}
+
+func TestTryAddMixed(t *testing.T) {
+	c, _ := NewCompiler()
+	if err := c.AddString("rule a { condition: true }", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TryAddString("rule a { condition: false }", ""); !errors.Is(err, ErrDuplicatedIdentifier) {
+		t.Errorf("expected duplicated identifier, got %v", err)
+	}
+	if err := c.TryAddString("rule b { condition: a }", ""); err == nil {
+		t.Error("expected error for reference to rule outside of the source")
+	}
+	if err := c.TryAddString("rule b { condition: true }", ""); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.GetRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.GetRules()); n != 2 {
+		t.Errorf("expected 2 rules, got %d", n)
+	}
+}
+
+func TestLoadRepository(t *testing.T) {
+	r, reports, err := LoadRepository([]Source{
+		{Filename: "good.yar", Rules: []byte("rule good { strings: $a = \"a\" condition: $a }")},
+		{Filename: "bad.yar", Rules: []byte("rule bad {\n  condition: undefined_var\n}")},
+		{Filename: "missing.yar"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.GetRules()); n != 1 {
+		t.Errorf("expected 1 rule, got %d", n)
+	}
+	if reports[0].Err != nil || len(reports[0].Warnings) == 0 {
+		t.Errorf("good.yar: unexpected report %+v", reports[0])
+	}
+	if reports[1].Err == nil || len(reports[1].Errors) != 1 || reports[1].Errors[0].Filename != "bad.yar" {
+		t.Errorf("bad.yar: unexpected report %+v", reports[1])
+	}
+	if reports[2].Err == nil {
+		t.Errorf("missing.yar: unexpected report %+v", reports[2])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var sources []Source
+	for i := 0; i < 20; i++ {
+		rules := fmt.Sprintf("rule r%d { condition: true }", i)
+		if i%5 == 0 {
+			rules = fmt.Sprintf("rule r%d {\n  condition: x\n}", i)
+		}
+		sources = append(sources, Source{Filename: fmt.Sprintf("r%d.yar", i), Rules: []byte(rules)})
+	}
+	reports := Validate(sources...)
+	if len(reports) != len(sources) {
+		t.Fatalf("expected %d reports, got %d", len(sources), len(reports))
+	}
+	for i, rep := range reports {
+		if rep.Filename != sources[i].Filename {
+			t.Errorf("report %d: unexpected filename %s", i, rep.Filename)
+		}
+		if failed := rep.Err != nil; failed != (i%5 == 0) {
+			t.Errorf("%s: unexpected error %v", rep.Filename, rep.Err)
+		} else if failed && (len(rep.Errors) != 1 || rep.Errors[0].Line != 2) {
+			t.Errorf("%s: unexpected errors %+v", rep.Filename, rep.Errors)
+		}
+	}
+	v := Validator{Variables: map[string]interface{}{"x": true}, Workers: 2}
+	for _, rep := range v.Validate(sources...) {
+		if rep.Err != nil {
+			t.Errorf("%s: %v", rep.Filename, rep.Err)
+		}
+	}
+}
+
+func TestRepositoryRelativeInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-yara-repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0755)
+	if err := ioutil.WriteFile(filepath.Join(sub, "inc.yar"), []byte(`rule inc { condition: true }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := Source{Filename: filepath.Join(sub, "main.yar"), Rules: []byte(`include "inc.yar" rule main { condition: inc }`)}
+	r, reports, err := LoadRepository([]Source{src}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports[0].Err != nil || len(r.GetRules()) != 2 {
+		t.Errorf("LoadRepository: unexpected report %+v", reports[0])
+	}
+	if rep := Validate(src)[0]; rep.Err != nil {
+		t.Errorf("Validate: %v", rep.Err)
+	}
+}
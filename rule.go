@@ -192,6 +192,13 @@ func (r *Rule) IsPrivate() bool {
 	return private
 }
 
+// IsEnabled returns true unless the rule has been disabled.
+func (r *Rule) IsEnabled() bool {
+	enabled := r.cptr.flags&C.RULE_FLAGS_DISABLED == 0
+	runtime.KeepAlive(r)
+	return enabled
+}
+
 // IsGlobal returns true if the rule is marked as global.
 func (r *Rule) IsGlobal() bool {
 	global := r.cptr.flags&C.RULE_FLAGS_GLOBAL != 0
@@ -0,0 +1,73 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+// #include <yara.h>
+import "C"
+
+// RuleFilter decides whether a rule is part of a Scanner's rule
+// subset, see Scanner.SetRuleFilter. RuleQuery.Match can be used as
+// a RuleFilter.
+type RuleFilter func(r *Rule) bool
+
+// SetRuleFilter restricts the Scanner's results to the rules for
+// which filter returns true. Unlike Rule.Disable, this does not
+// affect other Scanners that use the same Rules: Callback methods
+// (RuleMatching, RuleNotMatching, TooManyMatches) are not called for
+// other rules, and GetProfilingInfo does not report them. Passing nil
+// removes the filter.
+//
+// filter is called at most once per rule until SetRuleFilter is
+// called again. Since the same filter may be used by several Scanners
+// at once, it must be safe for concurrent use.
+//
+// Note that libyara still evaluates the conditions of all enabled
+// rules, so that rules in the subset that refer to other rules
+// behave as usual, but the cost of evaluating rules outside the
+// subset is not avoided. To reduce scanning cost for all Scanners,
+// disable rules. In particular, global rules outside the subset
+// still apply: If one of them does not match, the rules in its
+// namespace, including those in the subset, do not match either.
+//
+// Messages from the console module are passed to ConsoleLog
+// regardless of the filter because libyara does not report which
+// rule has produced them, so they may originate from rules outside
+// the subset.
+func (s *Scanner) SetRuleFilter(filter RuleFilter) *Scanner {
+	s.ruleFilter = filter
+	s.ruleFilterCache = nil
+	return s
+}
+
+// ruleSelected returns true if rule passes the Scanner's rule filter.
+func (s *Scanner) ruleSelected(rule *C.YR_RULE) bool {
+	if s.ruleFilter == nil {
+		return true
+	}
+	selected, ok := s.ruleFilterCache[rule]
+	if !ok {
+		if s.ruleFilterCache == nil {
+			s.ruleFilterCache = make(map[*C.YR_RULE]bool)
+		}
+		selected = s.ruleFilter(&Rule{rule, s.rules})
+		s.ruleFilterCache[rule] = selected
+	}
+	return selected
+}
+
+// Filter returns a RuleFilter that selects the rules in the
+// selection.
+func (sel RuleSelection) Filter() RuleFilter {
+	set := make(map[*C.YR_RULE]struct{}, len(sel))
+	for _, rule := range sel {
+		set[rule.cptr] = struct{}{}
+	}
+	return func(r *Rule) bool {
+		_, ok := set[r.cptr]
+		return ok
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"testing"
+)
+
+type noMatchCollector struct {
+	MatchRules
+	notMatching []string
+}
+
+func (c *noMatchCollector) RuleNotMatching(_ *ScanContext, r *Rule) (bool, error) {
+	c.notMatching = append(c.notMatching, r.Identifier())
+	return false, nil
+}
+
+func TestScannerRuleFilter(t *testing.T) {
+	r := makeRules(t, `
+		rule a : family_a { condition: true }
+		rule b : family_b { condition: a }
+		rule c : family_b { condition: false }`)
+	q, err := ParseRuleQuery("tag:family_b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, _ := NewScanner(r)
+	defer s1.Destroy()
+	s2, _ := NewScanner(r)
+	defer s2.Destroy()
+	sel, _ := r.Select("a")
+
+	var c1, c2 noMatchCollector
+	if err := s1.SetRuleFilter(q.Match).SetCallback(&c1).ScanMem(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.SetRuleFilter(sel.Filter()).SetCallback(&c2).ScanMem(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(c1.MatchRules) != 1 || c1.MatchRules[0].Rule != "b" ||
+		len(c1.notMatching) != 1 || c1.notMatching[0] != "c" {
+		t.Errorf("scanner 1: matches %+v, not matching %v", c1.MatchRules, c1.notMatching)
+	}
+	if len(c2.MatchRules) != 1 || c2.MatchRules[0].Rule != "a" || len(c2.notMatching) != 0 {
+		t.Errorf("scanner 2: matches %+v, not matching %v", c2.MatchRules, c2.notMatching)
+	}
+
+	var m MatchRules
+	if err := s1.SetRuleFilter(nil).SetCallback(&m).ScanMem(nil); err != nil {
+		t.Fatal(err)
+	} else if len(m) != 2 {
+		t.Errorf("expected 2 matches without filter, got %+v", m)
+	}
+}
@@ -0,0 +1,466 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// RuleQuery is a compiled rule selection query, see ParseRuleQuery.
+type RuleQuery struct {
+	query string
+	expr  queryExpr
+}
+
+// ParseRuleQuery compiles a rule selection query. A query consists of
+// terms that are combined using "and", "or", "not", and parentheses:
+//
+//	ns:GLOB, namespace:GLOB   rule namespace matches GLOB
+//	id:GLOB, rule:GLOB, GLOB  rule identifier matches GLOB
+//	tag:GLOB                  one of the rule's tags matches GLOB
+//	meta.NAME                 the rule has a meta NAME
+//	meta.NAME OP VALUE        a meta NAME compares to VALUE
+//	private, global           the rule has the flag
+//	enabled, disabled         the rule is enabled or disabled
+//
+// Globs use the syntax of path.Match. OP is one of ==, !=, <, <=,
+// >, >=. VALUE is an integer, true, false, a double-quoted string,
+// or a bare word. Integers are compared numerically, also against
+// string metas that contain an integer. If a meta occurs several
+// times, it is sufficient that one of the values matches. Example:
+//
+//	tag:ransomware and meta.severity >= 7 and not ns:experimental
+func ParseRuleQuery(query string) (*RuleQuery, error) {
+	var p queryParser
+	if err := p.tokenize(query); err != nil {
+		return nil, fmt.Errorf("query %q: %w", query, err)
+	}
+	expr, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %w", query, err)
+	}
+	return &RuleQuery{query: query, expr: expr}, nil
+}
+
+// String returns the query text.
+func (q *RuleQuery) String() string { return q.query }
+
+// Match returns true if the rule is selected by the query.
+func (q *RuleQuery) Match(r *Rule) bool { return q.expr.eval(newQueryRule(r)) }
+
+// queryRule contains the rule properties that queries are evaluated
+// against.
+type queryRule struct {
+	identifier, namespace string
+	tags                  []string
+	metas                 []Meta
+	private, global       bool
+	enabled               bool
+}
+
+func newQueryRule(r *Rule) *queryRule {
+	return &queryRule{
+		identifier: r.Identifier(),
+		namespace:  r.Namespace(),
+		tags:       r.Tags(),
+		metas:      r.Metas(),
+		private:    r.IsPrivate(),
+		global:     r.IsGlobal(),
+		enabled:    r.IsEnabled(),
+	}
+}
+
+type queryExpr interface {
+	eval(r *queryRule) bool
+}
+
+type queryAnd [2]queryExpr
+
+func (e queryAnd) eval(r *queryRule) bool { return e[0].eval(r) && e[1].eval(r) }
+
+type queryOr [2]queryExpr
+
+func (e queryOr) eval(r *queryRule) bool { return e[0].eval(r) || e[1].eval(r) }
+
+type queryNot struct{ queryExpr }
+
+func (e queryNot) eval(r *queryRule) bool { return !e.queryExpr.eval(r) }
+
+type queryFunc func(r *queryRule) bool
+
+func (f queryFunc) eval(r *queryRule) bool { return f(r) }
+
+// queryMeta matches rules with a meta that compares to value. If op
+// is empty, the meta only has to exist.
+type queryMeta struct {
+	identifier string
+	op         string
+	value      interface{}
+}
+
+func (e queryMeta) eval(r *queryRule) bool {
+	for _, m := range r.metas {
+		if m.Identifier == e.identifier && (e.op == "" || compareMeta(m.Value, e.op, e.value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareMeta compares a meta value to a query value (int, bool, or
+// string).
+func compareMeta(meta interface{}, op string, value interface{}) bool {
+	var c int
+	switch value := value.(type) {
+	case int:
+		var i int
+		switch meta := meta.(type) {
+		case int:
+			i = meta
+		case string:
+			var err error
+			if i, err = strconv.Atoi(strings.TrimSpace(meta)); err != nil {
+				return op == "!="
+			}
+		default:
+			return op == "!="
+		}
+		switch {
+		case i < value:
+			c = -1
+		case i > value:
+			c = 1
+		}
+	case bool:
+		b, ok := meta.(bool)
+		if !ok {
+			if i, isInt := meta.(int); isInt {
+				b, ok = i != 0, true
+			}
+		}
+		switch op {
+		case "==":
+			return ok && b == value
+		case "!=":
+			return !ok || b != value
+		}
+		return false
+	case string:
+		var s string
+		switch meta := meta.(type) {
+		case string:
+			s = meta
+		case int:
+			s = strconv.Itoa(meta)
+		case bool:
+			s = strconv.FormatBool(meta)
+		}
+		c = strings.Compare(s, value)
+	}
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokenize splits a query into parentheses, comparison operators,
+// quoted strings (including the quotes), and words.
+func (p *queryParser) tokenize(s string) error {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			p.tokens = append(p.tokens, s[i:i+1])
+			i++
+		case strings.IndexByte("=!<>", c) >= 0:
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			op := s[i:j]
+			if op == "=" || op == "!" {
+				return fmt.Errorf("invalid operator %q", op)
+			}
+			p.tokens = append(p.tokens, op)
+			i = j
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string")
+			}
+			p.tokens = append(p.tokens, s[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(s) && strings.IndexByte(" \t\r\n()=!<>\"", s[j]) < 0; j++ {
+			}
+			p.tokens = append(p.tokens, s[i:j])
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *queryParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *queryParser) parseOr() (queryExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "or" {
+		p.next()
+		var right queryExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = queryOr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseAnd() (queryExpr, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peek() == "and" {
+		p.next()
+		var right queryExpr
+		if right, err = p.parseUnary(); err == nil {
+			left = queryAnd{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseUnary() (queryExpr, error) {
+	switch t := p.next(); t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of query")
+	case "not":
+		e, err := p.parseUnary()
+		return queryNot{e}, err
+	case "(":
+		e, err := p.parseOr()
+		if err == nil && p.next() != ")" {
+			err = fmt.Errorf("missing )")
+		}
+		return e, err
+	case ")", "and", "or", "==", "!=", "<", "<=", ">", ">=":
+		return nil, fmt.Errorf("unexpected %q", t)
+	default:
+		return p.parseTerm(t)
+	}
+}
+
+func (p *queryParser) parseTerm(t string) (queryExpr, error) {
+	switch t {
+	case "private":
+		return queryFunc(func(r *queryRule) bool { return r.private }), nil
+	case "global":
+		return queryFunc(func(r *queryRule) bool { return r.global }), nil
+	case "enabled":
+		return queryFunc(func(r *queryRule) bool { return r.enabled }), nil
+	case "disabled":
+		return queryFunc(func(r *queryRule) bool { return !r.enabled }), nil
+	}
+	if strings.HasPrefix(t, "meta.") {
+		e := queryMeta{identifier: strings.TrimPrefix(t, "meta.")}
+		if e.identifier == "" {
+			return nil, fmt.Errorf("missing meta identifier")
+		}
+		switch p.peek() {
+		case "==", "!=", "<", "<=", ">", ">=":
+			e.op = p.next()
+			v, err := parseQueryValue(p.next())
+			if err != nil {
+				return nil, err
+			}
+			if _, isBool := v.(bool); isBool && e.op != "==" && e.op != "!=" {
+				return nil, fmt.Errorf("operator %s cannot be used with boolean values", e.op)
+			}
+			e.value = v
+		}
+		return e, nil
+	}
+	field, pattern := "id", t
+	if i := strings.IndexByte(t, ':'); i >= 0 {
+		field, pattern = t[:i], t[i+1:]
+	}
+	if strings.HasPrefix(pattern, "\"") {
+		s, err := strconv.Unquote(pattern)
+		if err != nil {
+			return nil, err
+		}
+		pattern = s
+	} else if pattern == "" && p.peek() != "" && strings.HasPrefix(p.peek(), "\"") {
+		s, err := strconv.Unquote(p.next())
+		if err != nil {
+			return nil, err
+		}
+		pattern = s
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	match := func(s string) bool { ok, _ := path.Match(pattern, s); return ok }
+	switch field {
+	case "ns", "namespace":
+		return queryFunc(func(r *queryRule) bool { return match(r.namespace) }), nil
+	case "id", "rule":
+		return queryFunc(func(r *queryRule) bool { return match(r.identifier) }), nil
+	case "tag":
+		return queryFunc(func(r *queryRule) bool {
+			for _, tag := range r.tags {
+				if match(tag) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown field %q", field)
+}
+
+// parseQueryValue parses an integer, boolean, quoted string, or bare
+// word.
+func parseQueryValue(t string) (interface{}, error) {
+	switch {
+	case t == "" || t == "(" || t == ")" || strings.IndexByte("=!<>", t[0]) >= 0:
+		return nil, fmt.Errorf("missing value")
+	case t == "true":
+		return true, nil
+	case t == "false":
+		return false, nil
+	case strings.HasPrefix(t, "\""):
+		return strconv.Unquote(t)
+	}
+	if i, err := strconv.ParseInt(t, 0, 0); err == nil {
+		return int(i), nil
+	}
+	return t, nil
+}
+
+// RuleSelection is a set of rules returned by Rules.Select.
+type RuleSelection []Rule
+
+// Select returns the rules that are matched by query, see
+// ParseRuleQuery.
+func (r *Rules) Select(query string) (RuleSelection, error) {
+	q, err := ParseRuleQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return r.SelectQuery(q), nil
+}
+
+// SelectQuery returns the rules that are matched by q.
+func (r *Rules) SelectQuery(q *RuleQuery) (sel RuleSelection) {
+	for _, rule := range r.GetRules() {
+		if q.Match(&rule) {
+			sel = append(sel, rule)
+		}
+	}
+	return
+}
+
+// Enable enables all rules in the selection.
+func (sel RuleSelection) Enable() {
+	for i := range sel {
+		sel[i].Enable()
+	}
+}
+
+// Disable disables all rules in the selection.
+func (sel RuleSelection) Disable() {
+	for i := range sel {
+		sel[i].Disable()
+	}
+}
+
+// Identifiers returns the qualified identifiers of the selected
+// rules, see RuleState.
+func (sel RuleSelection) Identifiers() []string {
+	ids := make([]string, len(sel))
+	for i := range sel {
+		ids[i] = sel[i].qualifiedName()
+	}
+	return ids
+}
+
+// qualifiedName returns the rule identifier, prefixed with the
+// namespace and a dot unless it is the default namespace.
+func (r *Rule) qualifiedName() string {
+	name := qualifiedRuleName(r.cptr)
+	runtime.KeepAlive(r)
+	return name
+}
+
+// RuleState records whether rules are enabled. Keys are rule
+// identifiers, prefixed with the namespace and a dot unless the rule
+// is part of the default namespace, e.g. "experimental.foo". Since
+// it is a plain map, it can be stored in configuration files.
+type RuleState map[string]bool
+
+// EnabledState returns a snapshot of the enabled state of all rules.
+func (r *Rules) EnabledState() RuleState {
+	state := make(RuleState)
+	for _, rule := range r.GetRules() {
+		state[rule.qualifiedName()] = rule.IsEnabled()
+	}
+	return state
+}
+
+// RestoreEnabledState enables or disables rules according to state.
+// Rules that are not part of state are left unchanged, unknown
+// identifiers in state are ignored.
+func (r *Rules) RestoreEnabledState(state RuleState) {
+	for _, rule := range r.GetRules() {
+		enabled, ok := state[rule.qualifiedName()]
+		switch {
+		case !ok:
+		case enabled:
+			rule.Enable()
+		default:
+			rule.Disable()
+		}
+	}
+}
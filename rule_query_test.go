@@ -0,0 +1,93 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRuleQueryEval(t *testing.T) {
+	r := &queryRule{
+		identifier: "ransom_note",
+		namespace:  "default",
+		tags:       []string{"ransomware", "windows"},
+		metas:      []Meta{{"severity", 8}, {"author", "x"}, {"version", "12"}, {"author", "y"}, {"active", true}},
+		enabled:    true,
+	}
+	for query, expected := range map[string]bool{
+		`tag:ransomware and meta.severity >= 7 and not ns:experimental`: true,
+		`ransom_*`:                                           true,
+		`id:ransom_note and private`:                         false,
+		`meta.author == y`:                                   true,
+		`meta.author=="z" or meta.author!="x"`:               true,
+		`meta.version > 9`:                                   true,
+		`meta.version > "9"`:                                 false,
+		`meta.active == true and enabled`:                    true,
+		`meta.missing`:                                       false,
+		`not (tag:linux or global) and meta.severity < 0x10`: true,
+		`tag:"win*"`:                                         true,
+	} {
+		q, err := ParseRuleQuery(query)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+		} else if got := q.expr.eval(r); got != expected {
+			t.Errorf("%s: got %v, expected %v", query, got, expected)
+		}
+	}
+	for _, query := range []string{``, `tag:a and`, `(tag:a`, `meta.x >`, `meta.x = 1`, `foo:bar`, `meta.x < true`, `tag:[`} {
+		if _, err := ParseRuleQuery(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestRulesSelect(t *testing.T) {
+	c, err := NewCompiler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddString(`
+		rule a : ransomware { meta: severity = 9 condition: true }
+		rule b : ransomware { meta: severity = 3 condition: true }
+		private rule c { condition: true }`, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddString(`rule a : ransomware { meta: severity = 10 condition: true }`, "experimental"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.GetRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Destroy()
+	initial := r.EnabledState()
+	sel, err := r.Select(`tag:ransomware and meta.severity >= 7 and not ns:experimental`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := sel.Identifiers(); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Errorf("selected %v", ids)
+	}
+	sel, _ = r.Select("tag:ransomware")
+	sel.Disable()
+	disabled, _ := r.Select("disabled")
+	ids := disabled.Identifiers()
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"a", "b", "experimental.a"}) {
+		t.Errorf("disabled: %v", ids)
+	}
+	r.RestoreEnabledState(initial)
+	if disabled, _ := r.Select("disabled"); len(disabled) != 0 {
+		t.Errorf("disabled after restore: %v", disabled.Identifiers())
+	}
+	r.RestoreEnabledState(RuleState{"experimental.a": false})
+	if disabled, _ := r.Select("disabled"); !reflect.DeepEqual(disabled.Identifiers(), []string{"experimental.a"}) {
+		t.Errorf("disabled after restore: %v", disabled.Identifiers())
+	}
+}
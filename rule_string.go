@@ -0,0 +1,326 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+/*
+#include <yara.h>
+
+// string_data is a union accessor function.
+// (CGO does not represent them properly to Go code.)
+static const uint8_t* string_data(YR_STRING* s) {
+	return s->string;
+}
+*/
+import "C"
+import (
+	"regexp/syntax"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// StringKind distinguishes text strings, hex strings, and regular
+// expressions.
+type StringKind int
+
+const (
+	StringText StringKind = iota
+	StringHex
+	StringRegex
+)
+
+func (k StringKind) String() string {
+	switch k {
+	case StringHex:
+		return "hex"
+	case StringRegex:
+		return "regex"
+	}
+	return "text"
+}
+
+// StringModifiers describes the modifiers of a string.
+//
+// libyara does not store the arguments of the xor and base64
+// modifiers, i.e. the key range and the alphabet, in compiled rules,
+// so they are not available.
+type StringModifiers struct {
+	NoCase, ASCII, Wide, Fullword, Private bool
+	Xor, Base64, Base64Wide                bool
+}
+
+func (s *String) flags() uint32 {
+	flags := uint32(s.cptr.flags)
+	runtime.KeepAlive(s)
+	return flags
+}
+
+// Kind returns whether the string is a text string, a hex string,
+// or a regular expression.
+func (s *String) Kind() StringKind {
+	switch flags := s.flags(); {
+	case flags&C.STRING_FLAGS_HEXADECIMAL != 0:
+		return StringHex
+	case flags&C.STRING_FLAGS_REGEXP != 0:
+		return StringRegex
+	}
+	return StringText
+}
+
+// IsAnonymous returns true for strings declared as "$".
+func (s *String) IsAnonymous() bool {
+	return s.flags()&C.STRING_FLAGS_ANONYMOUS != 0
+}
+
+// Modifiers returns the string's modifiers.
+func (s *String) Modifiers() (m StringModifiers) {
+	flags := s.flags()
+	m.NoCase = flags&C.STRING_FLAGS_NO_CASE != 0
+	m.ASCII = flags&C.STRING_FLAGS_ASCII != 0
+	m.Wide = flags&C.STRING_FLAGS_WIDE != 0
+	m.Fullword = flags&C.STRING_FLAGS_FULL_WORD != 0
+	m.Private = flags&C.STRING_FLAGS_PRIVATE != 0
+	m.Xor = flags&C.STRING_FLAGS_XOR != 0
+	m.Base64 = flags&C.STRING_FLAGS_BASE64 != 0
+	m.Base64Wide = flags&C.STRING_FLAGS_BASE64_WIDE != 0
+	return
+}
+
+// data returns the string's content as stored by libyara: the text
+// for text strings and literal hex strings, the source for regular
+// expressions and other hex strings.
+func (s *String) data() []byte {
+	buf := C.GoBytes(unsafe.Pointer(C.string_data(s.cptr)), C.int(s.cptr.length))
+	runtime.KeepAlive(s)
+	return buf
+}
+
+// Literal returns the bytes of text strings and of hex strings
+// without wildcards, jumps, or alternatives, before wide, xor, or
+// base64 transformations have been applied.
+func (s *String) Literal() ([]byte, bool) {
+	if s.Kind() == StringText || s.flags()&C.STRING_FLAGS_LITERAL != 0 {
+		return s.data(), true
+	}
+	return nil, false
+}
+
+// Pattern returns the source of hex strings and regular expressions
+// that are not literals.
+func (s *String) Pattern() (string, bool) {
+	if _, ok := s.Literal(); ok {
+		return "", false
+	}
+	return string(s.data()), true
+}
+
+// LengthBounds returns the minimum and maximum length of data that
+// can be matched by the string. max is -1 if there is no upper
+// bound. ok is false if the bounds cannot be determined, e.g. for
+// base64 strings or for regular expressions that cannot be parsed.
+func (s *String) LengthBounds() (min, max int, ok bool) {
+	m := s.Modifiers()
+	if m.Base64 || m.Base64Wide {
+		return 0, 0, false
+	}
+	if lit, isLit := s.Literal(); isLit {
+		min, max, ok = len(lit), len(lit), true
+	} else if pattern, _ := s.Pattern(); s.Kind() == StringHex {
+		min, max, ok = hexLengthBounds(pattern)
+	} else {
+		min, max, ok = regexLengthBounds(pattern)
+	}
+	if !ok || !m.Wide {
+		return
+	}
+	if max > 0 {
+		max *= 2
+	}
+	if !m.ASCII {
+		min *= 2
+	}
+	return
+}
+
+// hexLengthBounds computes the length bounds for a hex string.
+func hexLengthBounds(pattern string) (min, max int, ok bool) {
+	pattern = strings.Trim(strings.TrimSpace(pattern), "{}")
+	var pos int
+	var alternatives func() (int, int, bool)
+	// sequence handles bytes and jumps up to the end of pattern, a
+	// "|" or a ")".
+	sequence := func() (min, max int, ok bool) {
+		for pos < len(pattern) {
+			switch c := pattern[pos]; {
+			case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '~':
+				pos++
+			case c == '|' || c == ')':
+				return min, max, true
+			case strings.HasPrefix(pattern[pos:], "//"):
+				if end := strings.IndexByte(pattern[pos:], '\n'); end >= 0 {
+					pos += end
+				} else {
+					pos = len(pattern)
+				}
+			case strings.HasPrefix(pattern[pos:], "/*"):
+				end := strings.Index(pattern[pos+2:], "*/")
+				if end < 0 {
+					return 0, 0, false
+				}
+				pos += end + 4
+			case c == '(':
+				pos++
+				amin, amax, aok := alternatives()
+				if !aok || pos >= len(pattern) || pattern[pos] != ')' {
+					return 0, 0, false
+				}
+				pos++
+				min += amin
+				if max >= 0 {
+					max = addBound(max, amax)
+				}
+			case c == '[':
+				end := strings.IndexByte(pattern[pos:], ']')
+				if end < 0 {
+					return 0, 0, false
+				}
+				jmin, jmax, jok := parseHexJump(pattern[pos+1 : pos+end])
+				if !jok {
+					return 0, 0, false
+				}
+				pos += end + 1
+				min += jmin
+				max = addBound(max, jmax)
+			default:
+				// A byte, possibly with wildcard nibbles
+				if pos+2 > len(pattern) {
+					return 0, 0, false
+				}
+				pos += 2
+				min++
+				max = addBound(max, 1)
+			}
+		}
+		return min, max, true
+	}
+	alternatives = func() (min, max int, ok bool) {
+		for first := true; ; first = false {
+			smin, smax, sok := sequence()
+			if !sok {
+				return 0, 0, false
+			}
+			if first || smin < min {
+				min = smin
+			}
+			if first || max >= 0 && (smax < 0 || smax > max) {
+				max = smax
+			}
+			if pos >= len(pattern) || pattern[pos] != '|' {
+				return min, max, true
+			}
+			pos++
+		}
+	}
+	min, max, ok = alternatives()
+	if pos != len(pattern) {
+		return 0, 0, false
+	}
+	return
+}
+
+// addBound adds to a maximum length; -1 means unbounded.
+func addBound(max, n int) int {
+	if max < 0 || n < 0 {
+		return -1
+	}
+	return max + n
+}
+
+// parseHexJump parses the contents of a jump: "n", "n-m", "n-", "-".
+func parseHexJump(jump string) (min, max int, ok bool) {
+	jump = strings.Replace(jump, " ", "", -1)
+	lo, hi := jump, jump
+	if i := strings.IndexByte(jump, '-'); i >= 0 {
+		lo, hi = jump[:i], jump[i+1:]
+	}
+	var err error
+	if lo != "" {
+		if min, err = strconv.Atoi(lo); err != nil {
+			return 0, 0, false
+		}
+	}
+	if hi == "" {
+		return min, -1, true
+	}
+	if max, err = strconv.Atoi(hi); err != nil {
+		return 0, 0, false
+	}
+	return min, max, true
+}
+
+// regexLengthBounds computes the length bounds for a regular
+// expression. libyara treats every character as a byte.
+func regexLengthBounds(pattern string) (min, max int, ok bool) {
+	// Strip delimiters and flags: /.../is
+	if i := strings.LastIndexByte(pattern, '/'); strings.HasPrefix(pattern, "/") && i > 0 {
+		pattern = pattern[1:i]
+	}
+	pattern = strings.Replace(pattern, "{,", "{0,", -1)
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0, 0, false
+	}
+	min, max = regexpBounds(re)
+	return min, max, true
+}
+
+func regexpBounds(re *syntax.Regexp) (min, max int) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune), len(re.Rune)
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1, 1
+	case syntax.OpCapture:
+		return regexpBounds(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		smin, smax := regexpBounds(re.Sub[0])
+		switch re.Op {
+		case syntax.OpStar:
+			return 0, -1
+		case syntax.OpPlus:
+			return smin, -1
+		case syntax.OpQuest:
+			return 0, smax
+		}
+		min = smin * re.Min
+		if re.Max < 0 || smax < 0 {
+			return min, -1
+		}
+		return min, smax * re.Max
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			smin, smax := regexpBounds(sub)
+			min += smin
+			max = addBound(max, smax)
+		}
+		return
+	case syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			smin, smax := regexpBounds(sub)
+			if i == 0 || smin < min {
+				min = smin
+			}
+			if i == 0 || max >= 0 && (smax < 0 || smax > max) {
+				max = smax
+			}
+		}
+		return
+	}
+	// Empty matches, anchors, word boundaries
+	return 0, 0
+}
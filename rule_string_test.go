@@ -0,0 +1,77 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"reflect"
+	"testing"
+)
+
+const base64TestAlphabet = "ZYXWVUTSRQPONMLKJIHGFEDCBAzyxwvutsrqponmlkjihgfedcba9876543210+/"
+
+func TestStringIntrospection(t *testing.T) {
+	r := makeRules(t, `
+rule s {
+  meta:
+    description = "rule { strings: $x = \"y\" xor(5) }"
+  strings:
+    $a = "foo" nocase wide ascii fullword private
+    $ = "bar" xor(1-0x10) // comment
+    $ = "baz" base64("`+base64TestAlphabet+`")
+    $h = { 4D 5A [2-4] ( 01 | 02 03 ) ?? }
+    $r = /ab+c{2,3}/
+  condition:
+    any of them
+}`)
+	strs := r.GetRules()[0].Strings()
+	if len(strs) != 5 {
+		t.Fatalf("expected 5 strings, got %d", len(strs))
+	}
+	type info struct {
+		Kind      StringKind
+		Anonymous bool
+		Modifiers StringModifiers
+		Literal   string
+		Min, Max  int
+		BoundsOK  bool
+	}
+	var got []info
+	for _, s := range strs {
+		lit, _ := s.Literal()
+		min, max, ok := s.LengthBounds()
+		got = append(got, info{s.Kind(), s.IsAnonymous(), s.Modifiers(), string(lit), min, max, ok})
+	}
+	expected := []info{
+		{StringText, false, StringModifiers{NoCase: true, ASCII: true, Wide: true, Fullword: true, Private: true}, "foo", 3, 6, true},
+		{StringText, true, StringModifiers{ASCII: true, Xor: true}, "bar", 3, 3, true},
+		{StringText, true, StringModifiers{Base64: true}, "baz", 0, 0, false},
+		{StringHex, false, StringModifiers{ASCII: true}, "", 6, 9, true},
+		{StringRegex, false, StringModifiers{ASCII: true}, "", 4, -1, true},
+	}
+	for i := range expected {
+		// Implicit ascii modifier differs between YARA versions
+		// for base64 strings.
+		got[i].Modifiers.ASCII = expected[i].Modifiers.ASCII
+		if !reflect.DeepEqual(got[i], expected[i]) {
+			t.Errorf("string %d: got %+v, expected %+v", i, got[i], expected[i])
+		}
+	}
+}
+
+func TestHexLengthBounds(t *testing.T) {
+	for pattern, expected := range map[string][2]int{
+		"{ 01 02 03 }":                        {3, 3},
+		"{ 01 [-] 02 }":                       {2, -1},
+		"{ 01 [3] ~02 ( 03 | 04 [1-2] 05 ) }": {6, 9},
+		"{ 01 /* 02 | ( */ 03 // [-]\n 04 }":  {3, 3},
+	} {
+		min, max, ok := hexLengthBounds(pattern)
+		if !ok || min != expected[0] || max != expected[1] {
+			t.Errorf("%s: got %d, %d, %v", pattern, min, max, ok)
+		}
+	}
+}
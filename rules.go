@@ -17,9 +17,11 @@ int scanCallbackFunc(YR_SCAN_CONTEXT*, int, void*, void*);
 */
 import "C"
 import (
+	"context"
 	"errors"
 	"io"
 	"runtime"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -28,7 +30,12 @@ import (
 //
 // Since this type contains a C pointer to a YR_RULES structure that
 // may be automatically freed, it should not be copied.
-type Rules struct{ cptr *C.YR_RULES }
+type Rules struct {
+	cptr *C.YR_RULES
+	// index is built on first use, see rules_index.go.
+	indexMu sync.Mutex
+	index   *ruleIndex
+}
 
 // A MatchRule represents a rule successfully matched against a block
 // of data.
@@ -64,7 +71,10 @@ const (
 
 func (sf ScanFlags) withReportFlags(sc ScanCallback) (i C.int) {
 	i = C.int(sf) | C.SCAN_FLAGS_REPORT_RULES_MATCHING
-	if _, ok := sc.(ScanCallbackNoMatch); ok {
+	// Non-matching rules are needed to determine ScanResult.Pending.
+	if _, ok := sc.(*ScanResult); ok {
+		i |= C.SCAN_FLAGS_REPORT_RULES_NOT_MATCHING
+	} else if _, ok := innerCallback(sc).(ScanCallbackNoMatch); ok {
 		i |= C.SCAN_FLAGS_REPORT_RULES_NOT_MATCHING
 	}
 	return
@@ -78,16 +88,17 @@ func (r *Rules) ScanMem(buf []byte, flags ScanFlags, timeout time.Duration, cb S
 	if len(buf) > 0 {
 		ptr = (*C.uint8_t)(unsafe.Pointer(&(buf[0])))
 	}
-	userData := cgoNewHandle(makeScanCallbackContainer(cb, r))
+	cbc := makeScanCallbackContainer(cb, r)
+	userData := cgoNewHandle(cbc)
 	defer userData.Delete()
-	err = newError(C.yr_rules_scan_mem(
+	err = cbc.finish(newError(C.yr_rules_scan_mem(
 		r.cptr,
 		ptr,
 		C.size_t(len(buf)),
 		flags.withReportFlags(cb),
 		C.YR_CALLBACK_FUNC(C.scanCallbackFunc),
 		unsafe.Pointer(&userData),
-		C.int(timeout/time.Second)))
+		C.int(timeout/time.Second))))
 	runtime.KeepAlive(r)
 	runtime.KeepAlive(buf)
 	return
@@ -104,15 +115,16 @@ func (r *Rules) ScanMem(buf []byte, flags ScanFlags, timeout time.Duration, cb S
 func (r *Rules) ScanFile(filename string, flags ScanFlags, timeout time.Duration, cb ScanCallback) (err error) {
 	cfilename := C.CString(filename)
 	defer C.free(unsafe.Pointer(cfilename))
-	userData := cgoNewHandle(makeScanCallbackContainer(cb, r))
+	cbc := makeScanCallbackContainer(cb, r)
+	userData := cgoNewHandle(cbc)
 	defer userData.Delete()
-	err = newError(C.yr_rules_scan_file(
+	err = cbc.finish(newError(C.yr_rules_scan_file(
 		r.cptr,
 		cfilename,
 		flags.withReportFlags(cb),
 		C.YR_CALLBACK_FUNC(C.scanCallbackFunc),
 		unsafe.Pointer(&userData),
-		C.int(timeout/time.Second)))
+		C.int(timeout/time.Second))))
 	runtime.KeepAlive(r)
 	return
 }
@@ -121,15 +133,16 @@ func (r *Rules) ScanFile(filename string, flags ScanFlags, timeout time.Duration
 // emitted by libyara, the corresponding method on the ScanCallback
 // object is called.
 func (r *Rules) ScanFileDescriptor(fd uintptr, flags ScanFlags, timeout time.Duration, cb ScanCallback) (err error) {
-	userData := cgoNewHandle(makeScanCallbackContainer(cb, r))
+	cbc := makeScanCallbackContainer(cb, r)
+	userData := cgoNewHandle(cbc)
 	defer userData.Delete()
-	err = newError(C._yr_rules_scan_fd(
+	err = cbc.finish(newError(C._yr_rules_scan_fd(
 		r.cptr,
 		C.int(fd),
 		flags.withReportFlags(cb),
 		C.YR_CALLBACK_FUNC(C.scanCallbackFunc),
 		unsafe.Pointer(&userData),
-		C.int(timeout/time.Second)))
+		C.int(timeout/time.Second))))
 	runtime.KeepAlive(r)
 	return
 }
@@ -138,15 +151,16 @@ func (r *Rules) ScanFileDescriptor(fd uintptr, flags ScanFlags, timeout time.Dur
 // every event emitted by libyara, the corresponding method on the
 // ScanCallback object is called.
 func (r *Rules) ScanProc(pid int, flags ScanFlags, timeout time.Duration, cb ScanCallback) (err error) {
-	userData := cgoNewHandle(makeScanCallbackContainer(cb, r))
+	cbc := makeScanCallbackContainer(cb, r)
+	userData := cgoNewHandle(cbc)
 	defer userData.Delete()
-	err = newError(C.yr_rules_scan_proc(
+	err = cbc.finish(newError(C.yr_rules_scan_proc(
 		r.cptr,
 		C.int(pid),
 		flags.withReportFlags(cb),
 		C.YR_CALLBACK_FUNC(C.scanCallbackFunc),
 		unsafe.Pointer(&userData),
-		C.int(timeout/time.Second)))
+		C.int(timeout/time.Second))))
 	runtime.KeepAlive(r)
 	return
 }
@@ -160,21 +174,68 @@ func (r *Rules) ScanMemBlocks(mbi MemoryBlockIterator, flags ScanFlags, timeout
 	cmbi := makeCMemoryBlockIterator(c)
 	defer C.free(cmbi.context)
 	defer ((*cgoHandle)(cmbi.context)).Delete()
-	userData := cgoNewHandle(makeScanCallbackContainer(cb, r))
+	cbc := makeScanCallbackContainer(cb, r)
+	userData := cgoNewHandle(cbc)
 	defer userData.Delete()
-	err = newError(C.yr_rules_scan_mem_blocks(
+	err = cbc.finish(wrapCallbackError(newError(C.yr_rules_scan_mem_blocks(
 		r.cptr,
 		cmbi,
 		flags.withReportFlags(cb)|C.SCAN_FLAGS_NO_TRYCATCH,
 		C.YR_CALLBACK_FUNC(C.scanCallbackFunc),
 		unsafe.Pointer(&userData),
-		C.int(timeout/time.Second)))
+		C.int(timeout/time.Second))), c.err))
 	runtime.KeepAlive(r)
 	runtime.KeepAlive(mbi)
 	runtime.KeepAlive(cmbi)
 	return
 }
 
+// scanContext runs scan on a temporary Scanner, so that ctx is
+// handled like in the Scanner's ScanXxxContext methods.
+func (r *Rules) scanContext(flags ScanFlags, cb ScanCallback, scan func(s *Scanner) error) error {
+	s, err := NewScanner(r)
+	if err != nil {
+		return err
+	}
+	defer s.Destroy()
+	return scan(s.SetFlags(flags).SetCallback(cb))
+}
+
+// ScanMemContext is like ScanMem, but the scan is aborted if ctx is
+// cancelled or its deadline expires. In that case, the returned error
+// wraps ctx.Err().
+//
+// The ScanXxxContext methods of Rules use a temporary Scanner, see
+// Scanner.ScanMemContext for details on how ctx is handled.
+func (r *Rules) ScanMemContext(ctx context.Context, buf []byte, flags ScanFlags, cb ScanCallback) error {
+	return r.scanContext(flags, cb, func(s *Scanner) error { return s.ScanMemContext(ctx, buf) })
+}
+
+// ScanFileContext is like ScanFile, but the scan is aborted if ctx is
+// cancelled or its deadline expires, see ScanMemContext.
+func (r *Rules) ScanFileContext(ctx context.Context, filename string, flags ScanFlags, cb ScanCallback) error {
+	return r.scanContext(flags, cb, func(s *Scanner) error { return s.ScanFileContext(ctx, filename) })
+}
+
+// ScanFileDescriptorContext is like ScanFileDescriptor, but the scan
+// is aborted if ctx is cancelled or its deadline expires, see
+// ScanMemContext.
+func (r *Rules) ScanFileDescriptorContext(ctx context.Context, fd uintptr, flags ScanFlags, cb ScanCallback) error {
+	return r.scanContext(flags, cb, func(s *Scanner) error { return s.ScanFileDescriptorContext(ctx, fd) })
+}
+
+// ScanProcContext is like ScanProc, but the scan is aborted if ctx is
+// cancelled or its deadline expires, see ScanMemContext.
+func (r *Rules) ScanProcContext(ctx context.Context, pid int, flags ScanFlags, cb ScanCallback) error {
+	return r.scanContext(flags, cb, func(s *Scanner) error { return s.ScanProcContext(ctx, pid) })
+}
+
+// ScanMemBlocksContext is like ScanMemBlocks, but the scan is aborted
+// if ctx is cancelled or its deadline expires, see ScanMemContext.
+func (r *Rules) ScanMemBlocksContext(ctx context.Context, mbi MemoryBlockIterator, flags ScanFlags, cb ScanCallback) error {
+	return r.scanContext(flags, cb, func(s *Scanner) error { return s.ScanMemBlocksContext(ctx, mbi) })
+}
+
 // Save writes a compiled ruleset to filename.
 func (r *Rules) Save(filename string) (err error) {
 	cfilename := C.CString(filename)
@@ -241,6 +302,9 @@ func (r *Rules) Destroy() {
 		C.yr_rules_destroy(r.cptr)
 		r.cptr = nil
 	}
+	r.indexMu.Lock()
+	r.index = nil
+	r.indexMu.Unlock()
 	runtime.SetFinalizer(r, nil)
 }
 
@@ -17,6 +17,7 @@ static YR_RULE* find_rule(YR_RULES* r, unsigned int rule_idx) {
 */
 import "C"
 import (
+	"context"
 	"reflect"
 	"runtime"
 	"unsafe"
@@ -87,15 +88,38 @@ type ScanCallbackTooManyMatches interface {
 // pointers.
 type scanCallbackContainer struct {
 	ScanCallback
+	// inner is used to look up the optional callback interfaces.
+	inner ScanCallback
 	rules *Rules
 	cdata []unsafe.Pointer
+	// err holds the error returned by the last failing callback
+	// method or a *CallbackPanicError.
+	err error
+	// ctx is checked for cancellation before every callback. It
+	// can be nil.
+	ctx context.Context
+	// aborted is set if a callback method has requested to abort
+	// the scan.
+	aborted bool
+	// ruleSelected is set if the Scanner has a rule filter.
+	ruleSelected func(*C.YR_RULE) bool
+	// reported records the rules that have been reported to a
+	// *ScanResult, see ScanResult.Pending.
+	reported map[*C.YR_RULE]struct{}
+	// tooManyMatches is the string for which
+	// CALLBACK_MSG_TOO_MANY_MATCHES has been reported last.
+	tooManyMatches *C.YR_STRING
 }
 
 // makeScanCallbackContainer sets up a scanCallbackContainer with a
 // finalizer method that that frees any stored C pointers when the
 // container is garbage-collected.
 func makeScanCallbackContainer(sc ScanCallback, r *Rules) *scanCallbackContainer {
-	c := &scanCallbackContainer{sc, r, nil}
+	c := &scanCallbackContainer{ScanCallback: sc, inner: innerCallback(sc), rules: r}
+	if res, ok := sc.(*ScanResult); ok {
+		res.reset()
+		c.reported = make(map[*C.YR_RULE]struct{})
+	}
 	runtime.SetFinalizer(c, (*scanCallbackContainer).finalize)
 	return c
 }
@@ -103,6 +127,44 @@ func makeScanCallbackContainer(sc ScanCallback, r *Rules) *scanCallbackContainer
 // addCPointer adds a C pointer that can later be freed using free().
 func (c *scanCallbackContainer) addCPointer(p unsafe.Pointer) { c.cdata = append(c.cdata, p) }
 
+// finish attaches the error returned by a callback method to a YARA
+// error that has been caused by it. If the callback object is a
+// *ScanResult, the outcome of the scan is recorded there.
+//
+// For errors caused by a string with too many matches, the string's
+// rule and namespace are added if they have not been set using the
+// Scanner's last error information.
+func (c *scanCallbackContainer) finish(err error) error {
+	err = wrapCallbackError(err, c.err)
+	if e, ok := err.(Error); ok && e.Code == ERROR_TOO_MANY_MATCHES && e.RuleIdentifier == "" && c.tooManyMatches != nil {
+		str := String{c.tooManyMatches, c.rules}
+		rule := Rule{C.find_rule(c.rules.cptr, c.tooManyMatches.rule_idx), c.rules}
+		e.Namespace, e.RuleIdentifier, e.StringIdentifier = rule.Namespace(), rule.Identifier(), str.Identifier()
+		err = e
+	}
+	if r, ok := c.ScanCallback.(*ScanResult); ok {
+		r.finish(err, c.aborted)
+		if r.Status != ScanComplete {
+			r.Pending = c.pending()
+		}
+	}
+	return err
+}
+
+// pending returns the enabled, public rules that pass the rule filter
+// but have not been reported.
+func (c *scanCallbackContainer) pending() (rules []Rule) {
+	for _, rule := range c.rules.GetRules() {
+		if _, ok := c.reported[rule.cptr]; ok ||
+			rule.cptr.flags&(C.RULE_FLAGS_PRIVATE|C.RULE_FLAGS_DISABLED) != 0 ||
+			c.ruleSelected != nil && !c.ruleSelected(rule.cptr) {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
 // finalize frees stored C pointers
 func (c *scanCallbackContainer) finalize() {
 	for _, p := range c.cdata {
@@ -113,30 +175,58 @@ func (c *scanCallbackContainer) finalize() {
 }
 
 //export scanCallbackFunc
-func scanCallbackFunc(ctx *C.YR_SCAN_CONTEXT, message C.int, messageData, userData unsafe.Pointer) C.int {
+func scanCallbackFunc(ctx *C.YR_SCAN_CONTEXT, message C.int, messageData, userData unsafe.Pointer) (rc C.int) {
 	cbc, ok := cgoHandle(*(*uintptr)(userData)).Value().(*scanCallbackContainer)
 	s := &ScanContext{cptr: ctx}
 	if !ok {
 		return C.CALLBACK_ERROR
 	}
+	// Panics must not unwind through libyara's stack frames.
+	defer func() {
+		if p := recover(); p != nil {
+			cbc.err = newCallbackPanicError(p)
+			rc = C.CALLBACK_ERROR
+		}
+	}()
+	if cbc.ctx != nil {
+		if err := cbc.ctx.Err(); err != nil {
+			cbc.err = err
+			return C.CALLBACK_ERROR
+		}
+	}
 	if cbc.ScanCallback == nil {
 		return C.CALLBACK_CONTINUE
 	}
 	var abort bool
 	var err error
+	if cbc.ruleSelected != nil {
+		var rule *C.YR_RULE
+		switch message {
+		case C.CALLBACK_MSG_RULE_MATCHING, C.CALLBACK_MSG_RULE_NOT_MATCHING:
+			rule = (*C.YR_RULE)(messageData)
+		case C.CALLBACK_MSG_TOO_MANY_MATCHES:
+			rule = C.find_rule(cbc.rules.cptr, (*C.YR_STRING)(messageData).rule_idx)
+		}
+		if rule != nil && !cbc.ruleSelected(rule) {
+			return C.CALLBACK_CONTINUE
+		}
+	}
+	if cbc.reported != nil && (message == C.CALLBACK_MSG_RULE_MATCHING || message == C.CALLBACK_MSG_RULE_NOT_MATCHING) {
+		cbc.reported[(*C.YR_RULE)(messageData)] = struct{}{}
+	}
 	switch message {
 	case C.CALLBACK_MSG_RULE_MATCHING:
 		abort, err = cbc.ScanCallback.RuleMatching(s, &Rule{(*C.YR_RULE)(messageData), cbc.rules})
 	case C.CALLBACK_MSG_RULE_NOT_MATCHING:
-		if c, ok := cbc.ScanCallback.(ScanCallbackNoMatch); ok {
+		if c, ok := cbc.inner.(ScanCallbackNoMatch); ok {
 			abort, err = c.RuleNotMatching(s, &Rule{(*C.YR_RULE)(messageData), cbc.rules})
 		}
 	case C.CALLBACK_MSG_SCAN_FINISHED:
-		if c, ok := cbc.ScanCallback.(ScanCallbackFinished); ok {
+		if c, ok := cbc.inner.(ScanCallbackFinished); ok {
 			abort, err = c.ScanFinished(s)
 		}
 	case C.CALLBACK_MSG_IMPORT_MODULE:
-		if c, ok := cbc.ScanCallback.(ScanCallbackModuleImport); ok {
+		if c, ok := cbc.inner.(ScanCallbackModuleImport); ok {
 			mi := (*C.YR_MODULE_IMPORT)(messageData)
 			var buf []byte
 			if buf, abort, err = c.ImportModule(s, C.GoString(mi.module_name)); len(buf) == 0 {
@@ -151,15 +241,16 @@ func scanCallbackFunc(ctx *C.YR_SCAN_CONTEXT, message C.int, messageData, userDa
 			cbc.addCPointer(cbuf)
 		}
 	case C.CALLBACK_MSG_MODULE_IMPORTED:
-		if c, ok := cbc.ScanCallback.(ScanCallbackModuleImportFinished); ok {
+		if c, ok := cbc.inner.(ScanCallbackModuleImportFinished); ok {
 			abort, err = c.ModuleImported(s, &Object{(*C.YR_OBJECT)(messageData)})
 		}
 	case C.CALLBACK_MSG_CONSOLE_LOG:
-		if c, ok := cbc.ScanCallback.(ScanCallbackConsoleLog); ok {
+		if c, ok := cbc.inner.(ScanCallbackConsoleLog); ok {
 			c.ConsoleLog(s, C.GoString((*C.char)(messageData)))
 		}
 	case C.CALLBACK_MSG_TOO_MANY_MATCHES:
-		if c, ok := cbc.ScanCallback.(ScanCallbackTooManyMatches); ok {
+		cbc.tooManyMatches = (*C.YR_STRING)(messageData)
+		if c, ok := cbc.inner.(ScanCallbackTooManyMatches); ok {
 			yrString := String{(*C.YR_STRING)(messageData), cbc.rules}
 			rule := &Rule{
 				cptr:  C.find_rule(cbc.rules.cptr, yrString.cptr.rule_idx),
@@ -170,9 +261,11 @@ func scanCallbackFunc(ctx *C.YR_SCAN_CONTEXT, message C.int, messageData, userDa
 	}
 
 	if err != nil {
+		cbc.err = err
 		return C.CALLBACK_ERROR
 	}
 	if abort {
+		cbc.aborted = true
 		return C.CALLBACK_ABORT
 	}
 	return C.CALLBACK_CONTINUE
@@ -194,3 +287,113 @@ func (mr *MatchRules) RuleMatching(sc *ScanContext, r *Rule) (abort bool, err er
 	})
 	return
 }
+
+// ScanStatus describes how a scan has ended.
+type ScanStatus int
+
+const (
+	// ScanComplete means that the scan has run to completion.
+	ScanComplete ScanStatus = iota
+	// ScanTimedOut means that the scan has been stopped because
+	// the timeout or the context's deadline has been reached.
+	ScanTimedOut
+	// ScanAborted means that the scan has been stopped by a
+	// callback method, either by requesting an abort, returning
+	// an error or panicking, or because the context has been
+	// cancelled.
+	ScanAborted
+	// ScanFailed means that the scan has been stopped by any other
+	// error.
+	ScanFailed
+)
+
+func (s ScanStatus) String() string {
+	switch s {
+	case ScanComplete:
+		return "complete"
+	case ScanTimedOut:
+		return "timed out"
+	case ScanAborted:
+		return "aborted"
+	case ScanFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// ScanResult is used to collect matches like MatchRules, but it also
+// records how the scan has ended. If a scan ends prematurely, e.g.
+// because of ERROR_SCAN_TIMEOUT or ERROR_TOO_MANY_MATCHES, Matches
+// contains the matches that have been reported up to that point.
+//
+// The ScanResult object must be passed as the callback object to one
+// of the (*Rules).ScanXxxx or (*Scanner).ScanXxxx methods; it is
+// reset at the start of every scan.
+type ScanResult struct {
+	// Callback is an optional callback object. Its methods are
+	// called in addition to recording matches.
+	Callback ScanCallback
+	// Matches contains the matching rules that have been reported.
+	Matches MatchRules
+	// Status describes how the scan has ended.
+	Status ScanStatus
+	// Err is the error returned by the scan function.
+	Err error
+	// Namespace, Rule and String identify the rule and the string
+	// that have caused the error, if available. libyara only
+	// provides this information for ERROR_TOO_MANY_MATCHES.
+	Namespace, Rule, String string
+	// Pending contains the rules that had not been reported as
+	// matching or not matching when the scan ended. It is only set
+	// if Status is not ScanComplete. Private and disabled rules,
+	// and rules excluded by a Scanner's rule filter, are omitted.
+	Pending []Rule
+}
+
+// RuleMatching implements the ScanCallback interface for ScanResult.
+func (r *ScanResult) RuleMatching(sc *ScanContext, rule *Rule) (abort bool, err error) {
+	r.Matches.RuleMatching(sc, rule)
+	if r.Callback != nil {
+		abort, err = r.Callback.RuleMatching(sc, rule)
+	}
+	return
+}
+
+// reset is called by makeScanCallbackContainer.
+func (r *ScanResult) reset() {
+	*r = ScanResult{Callback: r.Callback}
+}
+
+func (r *ScanResult) finish(err error, aborted bool) {
+	r.Err = err
+	r.Status = ScanComplete
+	if aborted {
+		r.Status = ScanAborted
+	}
+	e, ok := err.(Error)
+	switch {
+	case err == nil:
+	case ok && e.Code == ERROR_SCAN_TIMEOUT:
+		r.Status = ScanTimedOut
+	case ok && e.Code == ERROR_CALLBACK_ERROR:
+		r.Status = ScanAborted
+		if e.Err == context.DeadlineExceeded {
+			r.Status = ScanTimedOut
+		}
+	default:
+		r.Status = ScanFailed
+	}
+	if ok {
+		r.Namespace, r.Rule, r.String = e.Namespace, e.RuleIdentifier, e.StringIdentifier
+	}
+}
+
+// innerCallback returns the callback object whose optional callback
+// interfaces are used. For a *ScanResult, this is its Callback
+// field.
+func innerCallback(sc ScanCallback) ScanCallback {
+	if r, ok := sc.(*ScanResult); ok && r.Callback != nil {
+		return r.Callback
+	}
+	return sc
+}
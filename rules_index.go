@@ -0,0 +1,150 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+// #include <yara.h>
+import "C"
+import "sort"
+
+// ruleIndex contains lookup tables for the rules of a ruleset. Since
+// compiled rules can not be changed, it is built only once. It does
+// not refer to the Rules object so that the Rules' finalizer can run.
+type ruleIndex struct {
+	rules       []*C.YR_RULE
+	byName      map[string]map[string]int
+	namespaces  []string
+	byNamespace map[string][]int
+	tags        []string
+	byTag       map[string][]int
+	byMeta      map[string][]int
+}
+
+// getIndex returns the ruleset's index, building it on first use.
+func (r *Rules) getIndex() *ruleIndex {
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+	if r.index == nil {
+		rules := r.GetRules()
+		idx := &ruleIndex{
+			rules:       make([]*C.YR_RULE, len(rules)),
+			byName:      make(map[string]map[string]int),
+			byNamespace: make(map[string][]int),
+			byTag:       make(map[string][]int),
+			byMeta:      make(map[string][]int),
+		}
+		for i := range rules {
+			rule := &rules[i]
+			idx.rules[i] = rule.cptr
+			ns := rule.Namespace()
+			if idx.byName[ns] == nil {
+				idx.byName[ns] = make(map[string]int)
+				idx.namespaces = append(idx.namespaces, ns)
+			}
+			idx.byName[ns][rule.Identifier()] = i
+			idx.byNamespace[ns] = append(idx.byNamespace[ns], i)
+			for _, tag := range rule.Tags() {
+				if len(idx.byTag[tag]) == 0 {
+					idx.tags = append(idx.tags, tag)
+				}
+				idx.byTag[tag] = appendIndex(idx.byTag[tag], i)
+			}
+			for _, m := range rule.Metas() {
+				idx.byMeta[m.Identifier] = appendIndex(idx.byMeta[m.Identifier], i)
+			}
+		}
+		sort.Strings(idx.namespaces)
+		sort.Strings(idx.tags)
+		r.index = idx
+	}
+	return r.index
+}
+
+// appendIndex appends i unless it is already the last element, so
+// that repeated tags or metas do not produce duplicates.
+func appendIndex(is []int, i int) []int {
+	if len(is) > 0 && is[len(is)-1] == i {
+		return is
+	}
+	return append(is, i)
+}
+
+// rulesAt returns the indexed rules.
+func (idx *ruleIndex) rulesAt(r *Rules, is []int) []Rule {
+	if len(is) == 0 {
+		return nil
+	}
+	rules := make([]Rule, len(is))
+	for j, i := range is {
+		rules[j] = Rule{idx.rules[i], r}
+	}
+	return rules
+}
+
+// Lookup returns the rule with the given namespace and identifier.
+// The namespace for rules that have been added without namespace is
+// "default". Like the other lookup methods, Lookup uses an index that
+// is built on first use.
+func (r *Rules) Lookup(namespace, identifier string) (*Rule, bool) {
+	idx := r.getIndex()
+	i, ok := idx.byName[namespace][identifier]
+	if !ok {
+		return nil, false
+	}
+	return &Rule{idx.rules[i], r}, true
+}
+
+// Namespaces returns the sorted names of the ruleset's namespaces.
+func (r *Rules) Namespaces() []string {
+	return append([]string(nil), r.getIndex().namespaces...)
+}
+
+// RulesInNamespace returns the rules in a namespace, in the order in
+// which they have been compiled.
+func (r *Rules) RulesInNamespace(namespace string) []Rule {
+	idx := r.getIndex()
+	return idx.rulesAt(r, idx.byNamespace[namespace])
+}
+
+// Tags returns the sorted list of tags that are used in the ruleset.
+func (r *Rules) Tags() []string {
+	return append([]string(nil), r.getIndex().tags...)
+}
+
+// RulesWithTag returns the rules that carry tag.
+func (r *Rules) RulesWithTag(tag string) []Rule {
+	idx := r.getIndex()
+	return idx.rulesAt(r, idx.byTag[tag])
+}
+
+// RulesWithMeta returns the rules that have at least one meta with
+// the identifier key.
+func (r *Rules) RulesWithMeta(key string) []Rule {
+	idx := r.getIndex()
+	return idx.rulesAt(r, idx.byMeta[key])
+}
+
+// ForEachRuleWithTag calls fn for each rule that carries tag until
+// fn returns false.
+func (r *Rules) ForEachRuleWithTag(tag string, fn func(*Rule) bool) {
+	idx := r.getIndex()
+	for _, i := range idx.byTag[tag] {
+		if !fn(&Rule{idx.rules[i], r}) {
+			return
+		}
+	}
+}
+
+// ForEachRuleWithMeta calls fn for each rule that has a meta with the
+// identifier key until fn returns false.
+func (r *Rules) ForEachRuleWithMeta(key string, fn func(*Rule) bool) {
+	idx := r.getIndex()
+	for _, i := range idx.byMeta[key] {
+		if !fn(&Rule{idx.rules[i], r}) {
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRulesIndex(t *testing.T) {
+	c, err := NewCompiler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddString(`
+		rule a : x y { meta: author = "a" author = "b" condition: true }
+		rule b : y { condition: true }`, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddString(`rule a : z { meta: score = 1 condition: true }`, "extra"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.GetRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Destroy()
+
+	if rule, ok := r.Lookup("extra", "a"); !ok || rule.Namespace() != "extra" || rule.Identifier() != "a" {
+		t.Errorf("Lookup(extra, a): %v, %v", rule, ok)
+	}
+	if _, ok := r.Lookup("default", "c"); ok {
+		t.Error("Lookup(default, c) succeeded")
+	}
+	if ns := r.Namespaces(); !reflect.DeepEqual(ns, []string{"default", "extra"}) {
+		t.Errorf("Namespaces: %v", ns)
+	}
+	if tags := r.Tags(); !reflect.DeepEqual(tags, []string{"x", "y", "z"}) {
+		t.Errorf("Tags: %v", tags)
+	}
+	ids := func(rules []Rule) (ids []string) {
+		for _, rule := range rules {
+			ids = append(ids, rule.Namespace()+":"+rule.Identifier())
+		}
+		return
+	}
+	for name, rules := range map[string][]Rule{
+		"RulesInNamespace(default)": r.RulesInNamespace("default"),
+		"RulesWithTag(y)":           r.RulesWithTag("y"),
+	} {
+		if got := ids(rules); !reflect.DeepEqual(got, []string{"default:a", "default:b"}) {
+			t.Errorf("%s: %v", name, got)
+		}
+	}
+	if got := ids(r.RulesWithMeta("author")); !reflect.DeepEqual(got, []string{"default:a"}) {
+		t.Errorf("RulesWithMeta(author): %v", got)
+	}
+	var visited []string
+	r.ForEachRuleWithTag("y", func(rule *Rule) bool {
+		visited = append(visited, rule.Identifier())
+		return false
+	})
+	if !reflect.DeepEqual(visited, []string{"a"}) {
+		t.Errorf("ForEachRuleWithTag: %v", visited)
+	}
+	r.Destroy()
+	if r.index != nil {
+		t.Error("index has not been dropped by Destroy")
+	}
+}
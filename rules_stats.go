@@ -0,0 +1,100 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+/*
+#include <yara.h>
+
+// rules_arena_size sums up the sizes of the arena buffers that hold
+// the compiled rules.
+static void rules_arena_size(YR_RULES* r, size_t* allocated, size_t* used) {
+	YR_ARENA* arena = r->arena;
+	*allocated = sizeof(YR_RULES) + sizeof(YR_ARENA);
+	*used = *allocated;
+	for (uint32_t i = 0; i < arena->num_buffers; i++) {
+		*allocated += arena->buffers[i].size;
+		*used += arena->buffers[i].used;
+	}
+}
+*/
+import "C"
+import "runtime"
+
+// RulesStats contains statistics about a compiled ruleset, as
+// returned by Rules.Stats. It does not contain a histogram of atom
+// lengths: YR_RULES_STATS has no such field, and atom lengths are not
+// recorded in compiled rules.
+type RulesStats struct {
+	// Rules and Strings are the number of rules and strings in the
+	// ruleset.
+	Rules   int
+	Strings int
+	// ACMatches is the total number of entries in the Aho-Corasick
+	// automaton's match lists.
+	ACMatches int
+	// ACRootMatchListLength is the length of the root node's match
+	// list, i.e. the number of strings without atoms that have to
+	// be checked at every offset.
+	ACRootMatchListLength int
+	// ACAverageMatchListLength is the average length of non-empty
+	// match lists.
+	ACAverageMatchListLength float64
+	// ACTopMatchListLengths contains the lengths of the longest
+	// match lists (at most 100), longest first.
+	ACTopMatchListLengths []int
+	// ACMatchListLengthPercentiles contains the 0th to 100th
+	// percentile of the match list lengths.
+	ACMatchListLengthPercentiles []int
+	// ACTablesSize is the number of slots in the Aho-Corasick
+	// transition and match tables.
+	ACTablesSize int
+	// MemoryAllocated is an estimate of the memory in bytes that is
+	// held by the compiled ruleset. MemoryUsed is the part that
+	// actually contains data. Memory that is allocated by modules or
+	// while scanning is not included.
+	//
+	// Both values are approximations: They are computed as the
+	// sum of the sizes of the buffers of the ruleset's arena and
+	// depend on libyara's internal memory layout, which may change
+	// between versions.
+	MemoryAllocated uint64
+	MemoryUsed      uint64
+}
+
+// Stats returns statistics about the ruleset. Changes in the
+// Aho-Corasick statistics, especially long match lists, indicate
+// changes in scanning performance.
+func (r *Rules) Stats() (*RulesStats, error) {
+	var cstats C.YR_RULES_STATS
+	if err := newError(C.yr_rules_get_stats(r.cptr, &cstats)); err != nil {
+		return nil, err
+	}
+	var allocated, used C.size_t
+	C.rules_arena_size(r.cptr, &allocated, &used)
+	runtime.KeepAlive(r)
+	stats := &RulesStats{
+		Rules:                    int(cstats.num_rules),
+		Strings:                  int(cstats.num_strings),
+		ACMatches:                int(cstats.ac_matches),
+		ACRootMatchListLength:    int(cstats.ac_root_match_list_length),
+		ACAverageMatchListLength: float64(cstats.ac_average_match_list_length),
+		ACTablesSize:             int(cstats.ac_tables_size),
+		MemoryAllocated:          uint64(allocated),
+		MemoryUsed:               uint64(used),
+	}
+	for _, l := range cstats.top_ac_match_list_lengths {
+		if l == 0 {
+			break
+		}
+		stats.ACTopMatchListLengths = append(stats.ACTopMatchListLengths, int(l))
+	}
+	stats.ACMatchListLengthPercentiles = make([]int, len(cstats.ac_match_list_length_pctls))
+	for i, l := range cstats.ac_match_list_length_pctls {
+		stats.ACMatchListLengthPercentiles[i] = int(l)
+	}
+	return stats, nil
+}
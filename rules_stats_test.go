@@ -0,0 +1,28 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import "testing"
+
+func TestRulesStats(t *testing.T) {
+	r := makeRules(t, `
+		rule a { strings: $a = "foobar" $b = "quux" condition: any of them }
+		rule b { strings: $c = { 01 02 03 04 } condition: $c }`)
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rules != 2 || stats.Strings != 3 {
+		t.Errorf("expected 2 rules and 3 strings, got %+v", stats)
+	}
+	if stats.ACMatches < 3 || len(stats.ACMatchListLengthPercentiles) != 101 {
+		t.Errorf("unexpected Aho-Corasick statistics: %+v", stats)
+	}
+	if stats.MemoryUsed == 0 || stats.MemoryAllocated < stats.MemoryUsed {
+		t.Errorf("unexpected memory estimate: %+v", stats)
+	}
+}
@@ -14,6 +14,7 @@ int scanCallbackFunc(YR_SCAN_CONTEXT*, int, void*, void*);
 */
 import "C"
 import (
+	"context"
 	"errors"
 	"runtime"
 	"time"
@@ -39,6 +40,21 @@ type Scanner struct {
 	// userData stores handle of the currently set callback object. It is
 	// allocated using malloc so that the GC does not mess with it.
 	userData *cgoHandle
+	// timeout is set by SetTimeout.
+	timeout time.Duration
+	// ctx is set by the ScanXxxContext methods for the duration of
+	// the scan.
+	ctx context.Context
+	// fileContext is set by SetFileContextVariables.
+	fileContext *FileContextVariables
+	// declared caches the types of the ruleset's variables.
+	declared map[string]VariableType
+	// computed is set by SetComputedVariable.
+	computed map[string]ComputedVariable
+	// ruleFilter is set by SetRuleFilter, its results are cached
+	// in ruleFilterCache.
+	ruleFilter      RuleFilter
+	ruleFilterCache map[*C.YR_RULE]bool
 }
 
 // Creates a new error that includes information a about the rule
@@ -128,6 +144,7 @@ func (s *Scanner) SetFlags(flags ScanFlags) *Scanner {
 
 // SetTimeout sets a timeout for the scanner.
 func (s *Scanner) SetTimeout(timeout time.Duration) *Scanner {
+	s.timeout = timeout
 	C.yr_scanner_set_timeout(s.cptr, C.int(timeout/time.Second))
 	return s
 }
@@ -147,7 +164,7 @@ func (s *Scanner) SetCallback(cb ScanCallback) *Scanner {
 // a cgoHandle. If no callback object has been
 // set, it is initialized with the pointer to an empty ScanRules
 // object. The handle must be deleted by the calling ScanXxxx function.
-func (s *Scanner) putCallbackData() {
+func (s *Scanner) putCallbackData() *scanCallbackContainer {
 	if _, ok := s.Callback.(ScanCallback); !ok {
 		s.Callback = &MatchRules{}
 	}
@@ -155,8 +172,14 @@ func (s *Scanner) putCallbackData() {
 		s.userData.Delete()
 		*s.userData = 0
 	}
-	*s.userData = cgoNewHandle(makeScanCallbackContainer(s.Callback, s.rules))
+	cbc := makeScanCallbackContainer(s.Callback, s.rules)
+	cbc.ctx = s.ctx
+	if s.ruleFilter != nil {
+		cbc.ruleSelected = s.ruleSelected
+	}
+	*s.userData = cgoNewHandle(cbc)
 	C.yr_scanner_set_callback(s.cptr, C.YR_CALLBACK_FUNC(C.scanCallbackFunc), unsafe.Pointer(s.userData))
+	return cbc
 }
 
 // ScanMem scans an in-memory buffer using the scanner.
@@ -164,11 +187,14 @@ func (s *Scanner) putCallbackData() {
 // If no callback object has been set for the scanner using
 // SetCAllback, it is initialized with an empty MatchRules object.
 func (s *Scanner) ScanMem(buf []byte) (err error) {
+	cbc := s.putCallbackData()
+	if err := s.prepareTarget(ScanTarget{Type: ScanTargetMem, Data: buf}); err != nil {
+		return cbc.finish(err)
+	}
 	var ptr *C.uint8_t
 	if len(buf) > 0 {
 		ptr = (*C.uint8_t)(unsafe.Pointer(&(buf[0])))
 	}
-	s.putCallbackData()
 	// SCAN_FLAGS_NO_TRYCATCH disables the YARA's exception handler that
 	// captures segfaults. Capturing these exceptions only makes sense
 	// while scanning memory-mapped files. When scanning in-memory data
@@ -178,10 +204,10 @@ func (s *Scanner) ScanMem(buf []byte) (err error) {
 	C.yr_scanner_set_flags(
 		s.cptr,
 		s.flags.withReportFlags(s.Callback)|C.SCAN_FLAGS_NO_TRYCATCH)
-	err = s.newScanError(C.yr_scanner_scan_mem(
+	err = cbc.finish(s.newScanError(C.yr_scanner_scan_mem(
 		s.cptr,
 		ptr,
-		C.size_t(len(buf))))
+		C.size_t(len(buf)))))
 	runtime.KeepAlive(s)
 	runtime.KeepAlive(buf)
 	return
@@ -196,15 +222,21 @@ func (s *Scanner) ScanMem(buf []byte) (err error) {
 // not be processed in a sensible way. It is recommended to avoid this
 // function and to obtain an os.File handle f using os.Open() and use
 // ScanFileDescriptor(f.Fd()) instead.
+//
+// Variables are defined from the file's metadata if this has been
+// enabled using SetFileContextVariables.
 func (s *Scanner) ScanFile(filename string) (err error) {
+	cbc := s.putCallbackData()
+	if err := s.prepareTarget(ScanTarget{Type: ScanTargetFile, Filename: filename}); err != nil {
+		return cbc.finish(err)
+	}
 	cfilename := C.CString(filename)
 	defer C.free(unsafe.Pointer(cfilename))
-	s.putCallbackData()
 	C.yr_scanner_set_flags(s.cptr, s.flags.withReportFlags(s.Callback))
-	err = s.newScanError(C.yr_scanner_scan_file(
+	err = cbc.finish(s.newScanError(C.yr_scanner_scan_file(
 		s.cptr,
 		cfilename,
-	))
+	)))
 	runtime.KeepAlive(s)
 	return
 }
@@ -214,12 +246,15 @@ func (s *Scanner) ScanFile(filename string) (err error) {
 // If no callback object has been set for the scanner using
 // SetCAllback, it is initialized with an empty MatchRules object.
 func (s *Scanner) ScanFileDescriptor(fd uintptr) (err error) {
-	s.putCallbackData()
+	cbc := s.putCallbackData()
+	if err := s.prepareTarget(ScanTarget{Type: ScanTargetFileDescriptor, Fd: fd}); err != nil {
+		return cbc.finish(err)
+	}
 	C.yr_scanner_set_flags(s.cptr, s.flags.withReportFlags(s.Callback))
-	err = s.newScanError(C._yr_scanner_scan_fd(
+	err = cbc.finish(s.newScanError(C._yr_scanner_scan_fd(
 		s.cptr,
 		C.int(fd),
-	))
+	)))
 	runtime.KeepAlive(s)
 	return
 }
@@ -229,12 +264,12 @@ func (s *Scanner) ScanFileDescriptor(fd uintptr) (err error) {
 // If no callback object has been set for the scanner using
 // SetCAllback, it is initialized with an empty MatchRules object.
 func (s *Scanner) ScanProc(pid int) (err error) {
-	s.putCallbackData()
+	cbc := s.putCallbackData()
 	C.yr_scanner_set_flags(s.cptr, s.flags.withReportFlags(s.Callback))
-	err = s.newScanError(C.yr_scanner_scan_proc(
+	err = cbc.finish(s.newScanError(C.yr_scanner_scan_proc(
 		s.cptr,
 		C.int(pid),
-	))
+	)))
 	runtime.KeepAlive(s)
 	return
 }
@@ -245,22 +280,104 @@ func (s *Scanner) ScanProc(pid int) (err error) {
 // SetCallback, it is initialized with an empty MatchRules object.
 func (s *Scanner) ScanMemBlocks(mbi MemoryBlockIterator) (err error) {
 	c := makeMemoryBlockIteratorContainer(mbi)
+	c.ctx = s.ctx
 	defer c.free()
 	cmbi := makeCMemoryBlockIterator(c)
 	defer C.free(cmbi.context)
 	defer ((*cgoHandle)(cmbi.context)).Delete()
-	s.putCallbackData()
+	cbc := s.putCallbackData()
 	C.yr_scanner_set_flags(s.cptr, s.flags.withReportFlags(s.Callback)|C.SCAN_FLAGS_NO_TRYCATCH)
-	err = s.newScanError(C.yr_scanner_scan_mem_blocks(
+	err = cbc.finish(wrapCallbackError(s.newScanError(C.yr_scanner_scan_mem_blocks(
 		s.cptr,
 		cmbi,
-	))
+	)), c.err))
 	runtime.KeepAlive(s)
 	runtime.KeepAlive(mbi)
 	runtime.KeepAlive(cmbi)
 	return
 }
 
+// withContext runs scan with ctx attached to the scanner.
+//
+// If ctx has a deadline that expires before the timeout set using
+// SetTimeout, the scanner's timeout is set to the remaining time for
+// the duration of the scan. libyara only checks the timeout
+// periodically while scanning, so a scan may run past the deadline.
+// Cancellation of ctx is checked before every callback method and
+// every memory block.
+func (s *Scanner) withContext(ctx context.Context, scan func() error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	timeout := s.timeout / time.Second * time.Second
+	var deadlineTimeout bool
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return context.DeadlineExceeded
+		}
+		if timeout == 0 || remaining < timeout {
+			timeout, deadlineTimeout = remaining, true
+		}
+	}
+	// libyara stores the timeout in nanoseconds, but
+	// yr_scanner_set_timeout only accepts seconds.
+	s.cptr.timeout = C.uint64_t(timeout)
+	defer C.yr_scanner_set_timeout(s.cptr, C.int(s.timeout/time.Second))
+	s.ctx = ctx
+	defer func() { s.ctx = nil }()
+	err = scan()
+	if e, ok := err.(Error); ok && e.Code == ERROR_SCAN_TIMEOUT && e.Err == nil && deadlineTimeout {
+		e.Err = context.DeadlineExceeded
+		err = e
+		if res, ok := s.Callback.(*ScanResult); ok {
+			res.Err = err
+		}
+	}
+	return
+}
+
+// ScanMemContext is like ScanMem, but the scan is aborted if ctx is
+// cancelled or its deadline expires. In that case, the returned
+// error wraps ctx.Err().
+//
+// If ctx has a deadline that expires before the timeout set using
+// SetTimeout, it is used as the timeout. libyara only checks the
+// timeout periodically, so a scan may run a little past the deadline.
+// Cancellation of ctx is checked before every callback method and
+// every memory block. This applies to all ScanXxxContext methods.
+func (s *Scanner) ScanMemContext(ctx context.Context, buf []byte) error {
+	return s.withContext(ctx, func() error { return s.ScanMem(buf) })
+}
+
+// ScanFileContext is like ScanFile, but the scan is aborted if ctx
+// is cancelled or its deadline expires. In that case, the returned
+// error wraps ctx.Err().
+func (s *Scanner) ScanFileContext(ctx context.Context, filename string) error {
+	return s.withContext(ctx, func() error { return s.ScanFile(filename) })
+}
+
+// ScanFileDescriptorContext is like ScanFileDescriptor, but the scan
+// is aborted if ctx is cancelled or its deadline expires. In that
+// case, the returned error wraps ctx.Err().
+func (s *Scanner) ScanFileDescriptorContext(ctx context.Context, fd uintptr) error {
+	return s.withContext(ctx, func() error { return s.ScanFileDescriptor(fd) })
+}
+
+// ScanProcContext is like ScanProc, but the scan is aborted if ctx
+// is cancelled or its deadline expires. In that case, the returned
+// error wraps ctx.Err().
+func (s *Scanner) ScanProcContext(ctx context.Context, pid int) error {
+	return s.withContext(ctx, func() error { return s.ScanProc(pid) })
+}
+
+// ScanMemBlocksContext is like ScanMemBlocks, but the scan is
+// aborted if ctx is cancelled or its deadline expires. In that case,
+// the returned error wraps ctx.Err().
+func (s *Scanner) ScanMemBlocksContext(ctx context.Context, mbi MemoryBlockIterator) error {
+	return s.withContext(ctx, func() error { return s.ScanMemBlocks(mbi) })
+}
+
 // GetLastErrorRule returns the Rule which caused the last error.
 //
 // The result is nil, if scanner returned no rule
@@ -291,10 +408,14 @@ type RuleProfilingInfo struct {
 }
 
 // GetProfilingInfo retrieves profiling information from the Scanner.
+// Rules that are excluded by the Scanner's rule filter are omitted.
 func (s *Scanner) GetProfilingInfo() (rpis []RuleProfilingInfo) {
 	rpi := C.yr_scanner_get_profiling_info(s.cptr)
 	defer C.yr_free(unsafe.Pointer(rpi))
 	for ; rpi.rule != nil; rpi = (*C.YR_RULE_PROFILING_INFO)(unsafe.Pointer(uintptr(unsafe.Pointer(rpi)) + unsafe.Sizeof(*rpi))) {
+		if !s.ruleSelected(rpi.rule) {
+			continue
+		}
 		rpis = append(rpis, RuleProfilingInfo{Rule{rpi.rule, s.rules}, uint64(rpi.cost)})
 	}
 	runtime.KeepAlive(s)
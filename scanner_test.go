@@ -7,11 +7,13 @@
 package yara
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func makeScanner(t *testing.T, rule string) *Scanner {
@@ -204,3 +206,177 @@ func TestScannerError(t *testing.T) {
 	}
 	t.Logf("ScanMem: got expected error, %s", err)
 }
+
+var errDatabase = errors.New("database unavailable")
+
+type errorScanCallback struct{}
+
+func (*errorScanCallback) RuleMatching(*ScanContext, *Rule) (bool, error) {
+	return false, errDatabase
+}
+
+func TestScannerCallbackError(t *testing.T) {
+	s := makeScanner(t, `
+		rule test { condition: true }
+		`)
+	err := s.SetCallback(&errorScanCallback{}).ScanMem([]byte{0, 0, 0, 0})
+	if !errors.Is(err, errDatabase) {
+		t.Fatalf("ScanMem: expected %v, got %v", errDatabase, err)
+	}
+	if e, ok := err.(Error); !ok || e.Code != ERROR_CALLBACK_ERROR {
+		t.Errorf("ScanMem: expected callback error, got %#v", err)
+	}
+	t.Logf("ScanMem: got expected error, %s", err)
+	r := makeRules(t, `
+		rule test { condition: true }
+		`)
+	if err := r.ScanMem([]byte{0, 0, 0, 0}, 0, 0, &errorScanCallback{}); !errors.Is(err, errDatabase) {
+		t.Fatalf("Rules.ScanMem: expected %v, got %v", errDatabase, err)
+	}
+}
+
+type panickingScanCallback struct{}
+
+func (*panickingScanCallback) RuleMatching(*ScanContext, *Rule) (bool, error) {
+	panic("plugin failure")
+}
+
+func TestScannerCallbackPanic(t *testing.T) {
+	s := makeScanner(t, `
+		rule test { condition: true }
+		`)
+	err := s.SetCallback(&panickingScanCallback{}).ScanMem([]byte{0, 0, 0, 0})
+	var pe *CallbackPanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("ScanMem: expected CallbackPanicError, got %v", err)
+	}
+	if pe.Value != "plugin failure" || len(pe.Stack) == 0 {
+		t.Errorf("unexpected CallbackPanicError: %#v", pe)
+	}
+	// The scanner can still be used after the panic.
+	var m MatchRules
+	if err := s.SetCallback(&m).ScanMem([]byte{0, 0, 0, 0}); err != nil {
+		t.Errorf("ScanMem after panic: %v", err)
+	} else if len(m) != 1 {
+		t.Errorf("ScanMem after panic: wanted 1 match, got %d", len(m))
+	}
+}
+
+type cancelingScanCallback struct {
+	cancel  func()
+	matched int
+}
+
+func (c *cancelingScanCallback) RuleMatching(*ScanContext, *Rule) (bool, error) {
+	c.matched++
+	c.cancel()
+	return false, nil
+}
+
+func TestScannerContextCancel(t *testing.T) {
+	s := makeScanner(t, `
+		rule t1 { condition: true }
+		rule t2 { condition: true }
+		`)
+	ctx, cancel := context.WithCancel(context.Background())
+	cb := &cancelingScanCallback{cancel: cancel}
+	err := s.SetCallback(cb).ScanMemContext(ctx, []byte{0, 0, 0, 0})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ScanMemContext: expected context.Canceled, got %v", err)
+	}
+	if cb.matched != 1 {
+		t.Errorf("expected scan to stop after 1 match, got %d", cb.matched)
+	}
+	if err := s.ScanMemContext(ctx, []byte{0, 0, 0, 0}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ScanMemContext with cancelled context: expected context.Canceled, got %v", err)
+	}
+}
+
+type slowIter struct {
+	testIter
+	delay time.Duration
+}
+
+func (it *slowIter) Next() *MemoryBlock {
+	time.Sleep(it.delay)
+	return it.testIter.Next()
+}
+
+func (it *slowIter) First() *MemoryBlock {
+	it.current = 0
+	return it.Next()
+}
+
+func TestScannerContextDeadline(t *testing.T) {
+	s := makeScanner(t, `rule t { condition: true }`)
+	var blocks []block
+	for i := 0; i < 100; i++ {
+		blocks = append(blocks, block{uint64(i * 16), []byte("aaaaaaaaaaaaaaaa")})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.ScanMemBlocksContext(ctx, &slowIter{testIter{data: blocks}, 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ScanMemBlocksContext: expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("scan took %v", elapsed)
+	}
+	t.Logf("ScanMemBlocksContext: got expected error, %s", err)
+}
+
+func TestRulesContext(t *testing.T) {
+	r := makeRules(t, `rule t { strings: $a = "abc" condition: $a }`)
+	var m MatchRules
+	if err := r.ScanMemContext(context.Background(), []byte(" abc "), 0, &m); err != nil {
+		t.Fatalf("ScanMemContext: %v", err)
+	} else if len(m) != 1 {
+		t.Errorf("ScanMemContext: wanted 1 match, got %d", len(m))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.ScanMemContext(ctx, []byte(" abc "), 0, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("ScanMemContext: expected context.Canceled, got %v", err)
+	}
+}
+
+type abortingScanCallback struct{}
+
+func (*abortingScanCallback) RuleMatching(*ScanContext, *Rule) (bool, error) {
+	return true, nil
+}
+
+func TestScanResult(t *testing.T) {
+	s := makeScanner(t, `
+		rule t1 { condition: true }
+		rule t2 { condition: true }
+		`)
+	var res ScanResult
+	if err := s.SetCallback(&res).ScanMem([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != ScanComplete || len(res.Matches) != 2 {
+		t.Errorf("expected complete scan with 2 matches, got %s with %d", res.Status, len(res.Matches))
+	}
+	if res.Pending != nil {
+		t.Errorf("expected no pending rules, got %v", res.Pending)
+	}
+	res.Callback = &abortingScanCallback{}
+	if err := s.ScanMem([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != ScanAborted || len(res.Matches) != 1 {
+		t.Errorf("expected aborted scan with 1 match, got %s with %d", res.Status, len(res.Matches))
+	}
+	if len(res.Pending) != 1 || res.Pending[0].Identifier() != "t2" {
+		t.Errorf("expected t2 to be pending, got %v", res.Pending)
+	}
+	res.Callback = &errorScanCallback{}
+	if err := s.ScanMem([]byte{0}); err == nil {
+		t.Fatal("expected error")
+	}
+	if res.Status != ScanAborted || !errors.Is(res.Err, errDatabase) {
+		t.Errorf("expected aborted scan with error, got %s, %v", res.Status, res.Err)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+/*
+#include <yara.h>
+
+// ext_vars_count returns the number of external variables that are
+// defined in a ruleset.
+static int ext_vars_count(YR_RULES* r) {
+	int n = 0;
+	YR_EXTERNAL_VARIABLE* v;
+	for (v = r->ext_vars_table; v->type != EXTERNAL_VARIABLE_TYPE_NULL; v++)
+		n++;
+	return n;
+}
+
+// ext_var_get is a union accessor function.
+// (CGO does not represent them properly to Go code.)
+static void ext_var_get(YR_RULES* r, int i, int* type, const char** identifier, int64_t* integer, double* f, const char** s) {
+	YR_EXTERNAL_VARIABLE* v = &r->ext_vars_table[i];
+	*type = v->type;
+	*identifier = v->identifier;
+	*integer = v->value.i;
+	*f = v->value.f;
+	*s = v->value.s;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// VariableType is the type of an external variable.
+type VariableType int
+
+const (
+	VariableInteger VariableType = iota
+	VariableFloat
+	VariableBoolean
+	VariableString
+)
+
+func (t VariableType) String() string {
+	switch t {
+	case VariableInteger:
+		return "integer"
+	case VariableFloat:
+		return "float"
+	case VariableBoolean:
+		return "boolean"
+	case VariableString:
+		return "string"
+	}
+	return fmt.Sprintf("VariableType(%d)", int(t))
+}
+
+// A Variable describes an external variable of a ruleset.
+type Variable struct {
+	Identifier string
+	Type       VariableType
+	// Value is the value that has been defined when compiling the
+	// rules or later using Rules.DefineVariable: int64, float64,
+	// bool, or string.
+	Value interface{}
+}
+
+// Variables returns the external variables that are declared in the
+// ruleset.
+func (r *Rules) Variables() (vars []Variable) {
+	n := int(C.ext_vars_count(r.cptr))
+	for i := 0; i < n; i++ {
+		var typ C.int
+		var id, s *C.char
+		var integer C.int64_t
+		var f C.double
+		C.ext_var_get(r.cptr, C.int(i), &typ, &id, &integer, &f, &s)
+		v := Variable{Identifier: C.GoString(id)}
+		switch typ {
+		case C.EXTERNAL_VARIABLE_TYPE_INTEGER:
+			v.Type, v.Value = VariableInteger, int64(integer)
+		case C.EXTERNAL_VARIABLE_TYPE_FLOAT:
+			v.Type, v.Value = VariableFloat, float64(f)
+		case C.EXTERNAL_VARIABLE_TYPE_BOOLEAN:
+			v.Type, v.Value = VariableBoolean, integer != 0
+		case C.EXTERNAL_VARIABLE_TYPE_STRING, C.EXTERNAL_VARIABLE_TYPE_MALLOC_STRING:
+			v.Type, v.Value = VariableString, C.GoString(s)
+		default:
+			continue
+		}
+		vars = append(vars, v)
+	}
+	runtime.KeepAlive(r)
+	return
+}
+
+var (
+	// ErrUndeclaredVariable is returned (wrapped in a
+	// VariableError) for variables that are not declared in a
+	// ruleset.
+	ErrUndeclaredVariable = errors.New("variable is not declared")
+	// ErrVariableType is returned (wrapped in a VariableError) for
+	// values that do not match the variable's type.
+	ErrVariableType = errors.New("wrong variable type")
+	// ErrVariableRange is returned (wrapped in a VariableError) for
+	// unsigned integer values that do not fit into an int64.
+	ErrVariableRange = errors.New("value out of range")
+)
+
+// A VariableError describes a problem with a single variable.
+type VariableError struct {
+	Identifier string
+	Err        error
+}
+
+func (e VariableError) Error() string { return fmt.Sprintf("variable %s: %v", e.Identifier, e.Err) }
+
+func (e VariableError) Unwrap() error { return e.Err }
+
+// VariableErrors is returned by ValidateVariables.
+type VariableErrors []VariableError
+
+func (errs VariableErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateVariables checks values against the external variables that
+// are declared in the ruleset. values is a map[string]interface{} or a
+// struct (or a pointer to one) whose fields are tagged with variable
+// names, e.g. `yara:"filename"`; untagged fields are ignored. Values
+// for variables that are not declared or whose types do not match
+// cause a VariableErrors value to be returned; variables that are not
+// set are not considered errors.
+func (r *Rules) ValidateVariables(values interface{}) error {
+	vals, err := variableValues(values)
+	if err != nil {
+		return err
+	}
+	declared := make(map[string]VariableType)
+	for _, v := range r.Variables() {
+		declared[v.Identifier] = v.Type
+	}
+	var errs VariableErrors
+	for _, v := range vals {
+		if typ, ok := declared[v.Identifier]; !ok {
+			errs = append(errs, VariableError{v.Identifier, ErrUndeclaredVariable})
+		} else if typ != v.Type {
+			errs = append(errs, VariableError{v.Identifier,
+				fmt.Errorf("%w: %s value for %s variable", ErrVariableType, v.Type, typ)})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// variableValue converts a Go value to a value that can be passed to
+// DefineVariable.
+func variableValue(v reflect.Value) (interface{}, VariableType, error) {
+	if !v.IsValid() {
+		return nil, 0, fmt.Errorf("%w: nil value", ErrVariableType)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), VariableBoolean, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), VariableInteger, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, 0, fmt.Errorf("%w: %d", ErrVariableRange, v.Uint())
+		}
+		return int64(v.Uint()), VariableInteger, nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), VariableFloat, nil
+	case reflect.String:
+		return v.String(), VariableString, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported type %s", ErrVariableType, v.Type())
+}
+
+// variableValues converts a map[string]interface{} or a tagged struct
+// to a list of variables, sorted by identifier.
+func variableValues(values interface{}) (vars []Variable, err error) {
+	add := func(id string, v reflect.Value) {
+		value, typ, verr := variableValue(v)
+		if verr != nil {
+			if err == nil {
+				err = VariableError{id, verr}
+			}
+			return
+		}
+		vars = append(vars, Variable{Identifier: id, Type: typ, Value: value})
+	}
+	rv := reflect.ValueOf(values)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map type %s", rv.Type())
+		}
+		for _, key := range rv.MapKeys() {
+			v := rv.MapIndex(key)
+			for v.Kind() == reflect.Interface {
+				v = v.Elem()
+			}
+			add(key.String(), v)
+		}
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			id := rt.Field(i).Tag.Get("yara")
+			if id == "" || id == "-" {
+				continue
+			}
+			add(id, rv.Field(i))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %T, expected map or struct", values)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Identifier < vars[j].Identifier })
+	return
+}
+
+// defineVariables calls define for every variable in values.
+func defineVariables(values interface{}, define func(string, interface{}) error) error {
+	vars, err := variableValues(values)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		if err := define(v.Identifier, v.Value); err != nil {
+			return VariableError{v.Identifier, err}
+		}
+	}
+	return nil
+}
+
+// DefineVariables defines variables for use by the scanner. values is
+// a map[string]interface{} or a struct (or a pointer to one) whose
+// fields are tagged with variable names:
+//
+//	type fileContext struct {
+//		Filename  string `yara:"filename"`
+//		Owner     string `yara:"owner"`
+//		IsSigned  bool   `yara:"is_signed"`
+//		RiskScore int    `yara:"risk_score"`
+//	}
+//
+// Untagged fields and fields tagged with `yara:"-"` are ignored.
+// Values of boolean, integer, floating point, and string kinds are
+// supported.
+func (s *Scanner) DefineVariables(values interface{}) error {
+	return defineVariables(values, s.DefineVariable)
+}
+
+// DefineVariables defines variables for use by the ruleset. See
+// Scanner.DefineVariables.
+func (r *Rules) DefineVariables(values interface{}) error {
+	return defineVariables(values, r.DefineVariable)
+}
+
+// DefineVariables defines variables for use by the compiler. See
+// Scanner.DefineVariables.
+func (c *Compiler) DefineVariables(values interface{}) error {
+	return defineVariables(values, c.DefineVariable)
+}
+
+// VariableValues returns the current values of the ruleset's external
+// variables, as defined by the compiler or by Rules.DefineVariable.
+// Values are int64, float64, bool, or string.
+func (r *Rules) VariableValues() map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, v := range r.Variables() {
+		values[v.Identifier] = v.Value
+	}
+	return values
+}
@@ -0,0 +1,104 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRulesVariables(t *testing.T) {
+	r, err := Compile(`rule v { condition: filename == "x" and score > 1 and signed and ratio < 0.5 }`,
+		map[string]interface{}{"filename": "", "score": 3, "signed": true, "ratio": 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Variable)
+	for _, v := range r.Variables() {
+		got[v.Identifier] = v
+	}
+	expected := map[string]Variable{
+		"filename": {"filename", VariableString, ""},
+		"score":    {"score", VariableInteger, int64(3)},
+		"signed":   {"signed", VariableBoolean, true},
+		"ratio":    {"ratio", VariableFloat, 0.25},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+
+	type context struct {
+		Filename string  `yara:"filename"`
+		Score    uint16  `yara:"score"`
+		Signed   bool    `yara:"signd"`
+		Ratio    int     `yara:"ratio"`
+		Other    float64 `yara:"-"`
+	}
+	err = r.ValidateVariables(&context{})
+	errs, ok := err.(VariableErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if errs[0].Identifier != "ratio" || !errors.Is(errs[0], ErrVariableType) ||
+		errs[1].Identifier != "signd" || !errors.Is(errs[1], ErrUndeclaredVariable) {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if err := r.ValidateVariables(map[string]interface{}{"score": 7, "filename": "a"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDefineVariables(t *testing.T) {
+	type fileContext struct {
+		Filename  string `yara:"filename"`
+		Owner     string `yara:"owner"`
+		IsSigned  bool   `yara:"is_signed"`
+		RiskScore int    `yara:"risk_score"`
+		internal  int
+	}
+	c, _ := NewCompiler()
+	if err := c.DefineVariables(fileContext{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddString(`rule r { condition: filename == "a.exe" and owner == "root" and not is_signed and risk_score > 5 }`, ""); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.GetRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := fileContext{Filename: "a.exe", Owner: "root", RiskScore: 10}
+	if err := r.DefineVariables(&ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"filename": "a.exe", "owner": "root", "is_signed": false, "risk_score": int64(10)}
+	if got := r.VariableValues(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+	s, _ := NewScanner(r)
+	var m MatchRules
+	ctx.IsSigned = true
+	if err := s.DefineVariables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetCallback(&m).ScanMem([]byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 0 {
+		t.Errorf("unexpected matches: %+v", m)
+	}
+	if err := s.DefineVariables(map[string]interface{}{"is_signed": []byte{}}); !errors.Is(err, ErrVariableType) {
+		t.Errorf("expected ErrVariableType, got %v", err)
+	}
+	if err := s.DefineVariables(map[string]interface{}{"is_signed": nil}); !errors.Is(err, ErrVariableType) {
+		t.Errorf("expected ErrVariableType for nil value, got %v", err)
+	}
+	if err := s.DefineVariables(map[string]interface{}{"risk_score": uint64(1 << 63)}); !errors.Is(err, ErrVariableRange) {
+		t.Errorf("expected ErrVariableRange, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright © 2015-2020 Hilko Bengen <bengen@hilluzination.de>
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package yara
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// WarningCategory classifies compiler warnings.
+type WarningCategory string
+
+const (
+	// WarningSlowString: A string may slow down scanning.
+	WarningSlowString WarningCategory = "slow-string"
+	// WarningSlowRegex: A regular expression contains unbounded
+	// repetitions.
+	WarningSlowRegex WarningCategory = "slow-regex"
+	// WarningNestedQuantifier: A regular expression contains
+	// nested repetitions.
+	WarningNestedQuantifier WarningCategory = "nested-quantifier"
+	// WarningLowAtomQuality: No good atom can be extracted from a
+	// string.
+	WarningLowAtomQuality WarningCategory = "low-atom-quality"
+	// WarningSlowLoop: A loop may slow down scanning.
+	WarningSlowLoop WarningCategory = "slow-loop"
+	// WarningUnusedString: A string is not referenced in the
+	// condition.
+	WarningUnusedString WarningCategory = "unused-string"
+	// WarningRegexCandidate: A string could be written as a
+	// regular expression.
+	WarningRegexCandidate WarningCategory = "regex-candidate"
+	// WarningDeprecated: A deprecated feature is used.
+	WarningDeprecated WarningCategory = "deprecated"
+	// WarningConstantCondition: An expression is always true or
+	// always false.
+	WarningConstantCondition WarningCategory = "constant-condition"
+	// WarningNonASCII: A string contains non-ASCII characters.
+	WarningNonASCII WarningCategory = "non-ascii"
+	// WarningOther is used for warnings that are not recognized.
+	WarningOther WarningCategory = "other"
+	// AllWarnings can be used with SetWarningAction and in rule
+	// metas to refer to all categories.
+	AllWarnings WarningCategory = "all"
+)
+
+// ErrWarningAsError is matched by the *CompileError that GetRules
+// returns if warnings have been treated as errors.
+var ErrWarningAsError = errors.New("warnings treated as errors")
+
+// warningPatterns is used to classify warnings by their text. The
+// first matching pattern wins. The patterns have been checked against
+// the warning messages of libyara 4.2 and 4.3.
+var warningPatterns = []struct {
+	re       *regexp.Regexp
+	category WarningCategory
+}{
+	{regexp.MustCompile(`(?i)slow(ing)? down scanning`), WarningSlowString},
+	{regexp.MustCompile(`(?i)consider using \.\{`), WarningSlowRegex},
+	{regexp.MustCompile(`(?i)slow loop`), WarningSlowLoop},
+	{regexp.MustCompile(`(?i)unreferenced string|is unused`), WarningUnusedString},
+	{regexp.MustCompile(`(?i)(could|may) be (a|written as a) reg`), WarningRegexCandidate},
+	{regexp.MustCompile(`(?i)deprecated`), WarningDeprecated},
+	{regexp.MustCompile(`(?i)always (true|false)`), WarningConstantCondition},
+	{regexp.MustCompile(`(?i)non-ascii`), WarningNonASCII},
+}
+
+func classifyWarning(text string) WarningCategory {
+	for _, p := range warningPatterns {
+		if p.re.MatchString(text) {
+			return p.category
+		}
+	}
+	return WarningOther
+}
+
+// WarningAction determines how warnings of a category are handled.
+type WarningAction int
+
+const (
+	// WarningKeep records warnings in Compiler.Warnings.
+	WarningKeep WarningAction = iota
+	// WarningIgnore suppresses warnings.
+	WarningIgnore
+	// WarningError records warnings in Compiler.Warnings and
+	// causes GetRules to fail.
+	WarningError
+)
+
+var warningActionNames = map[WarningAction]string{
+	WarningKeep:   "warn",
+	WarningIgnore: "ignore",
+	WarningError:  "error",
+}
+
+func (a WarningAction) String() string {
+	if s, ok := warningActionNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("WarningAction(%d)", int(a))
+}
+
+// SetWarningAction sets the action for warnings of category, which
+// may be AllWarnings. Actions for specific categories take precedence
+// over AllWarnings.
+func (c *Compiler) SetWarningAction(category WarningCategory, action WarningAction) {
+	if c.warningActions == nil {
+		c.warningActions = make(map[WarningCategory]WarningAction)
+	}
+	c.warningActions[category] = action
+}
+
+// SetWarningMetaKey enables per-rule warning actions that override
+// the actions set by SetWarningAction. The value of the rule meta key
+// is a comma-separated list of categories, each optionally followed
+// by "=" and an action name ("warn", "ignore", "error"); the default
+// action is "ignore". For example:
+//
+//	rule r {
+//	  meta:
+//	    warnings = "slow-string, unused-string=error"
+//	  ...
+//	}
+//
+// Since rule metas are only available after compilation, per-rule
+// actions are applied by GetRules.
+func (c *Compiler) SetWarningMetaKey(key string) {
+	c.warningMetaKey = key
+}
+
+// parseWarningMeta parses the value of a rule meta as described for
+// SetWarningMetaKey.
+func parseWarningMeta(value string) (map[WarningCategory]WarningAction, error) {
+	actions := make(map[WarningCategory]WarningAction)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		action := WarningIgnore
+		if i := strings.IndexByte(item, '='); i >= 0 {
+			name := strings.TrimSpace(item[i+1:])
+			item = strings.TrimSpace(item[:i])
+			found := false
+			for a, s := range warningActionNames {
+				if s == name {
+					action, found = a, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown warning action %q", name)
+			}
+		}
+		actions[WarningCategory(item)] = action
+	}
+	return actions, nil
+}
+
+// warningAction determines the action for a warning, looking at
+// per-rule actions first.
+func (c *Compiler) warningAction(category WarningCategory, ruleActions map[WarningCategory]WarningAction) WarningAction {
+	for _, actions := range []map[WarningCategory]WarningAction{ruleActions, c.warningActions} {
+		if a, ok := actions[category]; ok {
+			return a
+		} else if a, ok := actions[AllWarnings]; ok {
+			return a
+		}
+	}
+	return WarningKeep
+}
+
+// applyWarningPolicy moves the warnings that have been reported during
+// the current AddXxx call to c.Warnings, leaving out those that are
+// ignored.
+func (c *Compiler) applyWarningPolicy() {
+	messages := c.messages[:0]
+	for _, m := range c.messages {
+		if m.Severity == SeverityWarning {
+			c.warnings = append(c.warnings, m)
+			if c.warningAction(m.Category, nil) == WarningIgnore {
+				continue
+			}
+			c.Warnings = append(c.Warnings, m)
+		}
+		messages = append(messages, m)
+	}
+	c.messages = messages
+}
+
+// checkPromotedWarnings applies per-rule actions found in r's metas
+// to all warnings, updates c.Warnings, and returns a *CompileError if
+// warnings have been promoted to errors. r may be nil if no warning
+// meta key has been set.
+func (c *Compiler) checkPromotedWarnings(r *Rules) error {
+	ruleActions := make(map[string]map[WarningCategory]WarningAction)
+	var metaErrors []CompilerMessage
+	if c.warningMetaKey != "" {
+		for _, rule := range r.GetRules() {
+			name := rule.Identifier()
+			if ns := rule.Namespace(); ns != "default" {
+				name = ns + "." + name
+			}
+			for _, m := range rule.Metas() {
+				value, ok := m.Value.(string)
+				if m.Identifier != c.warningMetaKey || !ok {
+					continue
+				}
+				actions, err := parseWarningMeta(value)
+				if err != nil {
+					metaErrors = append(metaErrors, CompilerMessage{
+						Text:     fmt.Sprintf("rule \"%s\": meta %s: %v", rule.Identifier(), m.Identifier, err),
+						Rule:     name,
+						Severity: SeverityError,
+						Err:      err,
+					})
+					continue
+				}
+				ruleActions[name] = actions
+			}
+		}
+	}
+	var warnings, promoted []CompilerMessage
+	for _, m := range c.warnings {
+		switch c.warningAction(m.Category, ruleActions[m.Rule]) {
+		case WarningIgnore:
+			continue
+		case WarningError:
+			p := m
+			p.Severity = SeverityError
+			promoted = append(promoted, p)
+		}
+		warnings = append(warnings, m)
+	}
+	c.Warnings = warnings
+	promoted = append(promoted, metaErrors...)
+	if len(promoted) == 0 {
+		return nil
+	}
+	return &CompileError{
+		Messages: promoted,
+		text:     fmt.Sprintf("%d warning(s) treated as errors", len(promoted)),
+		promoted: true,
+	}
+}